@@ -0,0 +1,739 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kovetskiy/mark/pkg/log"
+	"github.com/kovetskiy/mark/pkg/mark"
+	"github.com/kovetskiy/mark/pkg/mark/render"
+	"github.com/reconquest/karma-go"
+)
+
+const (
+	// ExportHTML is a format of export into static site.
+	ExportHTML = `html`
+
+	// ExportPDF is a format of export into PDF document per space.
+	ExportPDF = `pdf`
+)
+
+// pdfConverters are commands which convert HTML file into PDF file. The
+// first one which is found in PATH is used.
+var pdfConverters = [][]string{
+	{"wkhtmltopdf", "--quiet", "--enable-local-file-access", "{input}", "{output}"},
+	{"chromium", "--headless", "--disable-gpu", "--print-to-pdf={output}", "{input}"},
+	{"chromium-browser", "--headless", "--disable-gpu", "--print-to-pdf={output}", "{input}"},
+	{"google-chrome", "--headless", "--disable-gpu", "--print-to-pdf={output}", "{input}"},
+}
+
+// exportTemplates render pages of exported site and its index page, which
+// lists page trees of all spaces.
+var exportTemplates = template.Must(template.New("export").Parse(`
+{{ define "head" }}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .Title }}</title>
+<style>{{ .Stylesheet }}
+.export-breadcrumbs { color: #6b778c; margin-bottom: 8px; }
+.export-breadcrumbs a { color: #6b778c; }
+.export-children { border-top: 1px solid #dfe1e6; margin-top: 24px; }
+.export-page { page-break-before: always; }
+</style>
+</head>
+<body>
+{{ end }}
+
+{{ define "tree" }}<ul>
+{{ range . }}<li><a href="{{ .Link }}">{{ .Title }}</a>{{ if .Children }}
+{{ template "tree" .Children }}{{ end }}</li>
+{{ end }}</ul>{{ end }}
+
+{{ define "page" }}{{ template "head" . }}
+<nav class="export-breadcrumbs"><a href="{{ .Index }}">{{ .Space }}</a>
+{{- range .Parents }} / <a href="{{ .Link }}">{{ .Title }}</a>{{ end }}</nav>
+<h1>{{ .Title }}</h1>
+{{ .Body }}
+{{ if .Children }}<div class="export-children">
+<h2>Child pages</h2>
+{{ template "tree" .Children }}
+</div>{{ end }}
+</body>
+</html>
+{{ end }}
+
+{{ define "document" }}{{ template "head" . }}
+<h1>{{ .Title }}</h1>
+{{ template "tree" .Children }}
+{{ range .Pages }}<div class="export-page" id="{{ .ID }}">
+<nav class="export-breadcrumbs">{{ $.Title }}
+{{- range .Parents }} / <a href="{{ .Link }}">{{ .Title }}</a>{{ end }}</nav>
+<h1>{{ .Title }}</h1>
+{{ .Body }}
+</div>
+{{ end }}
+</body>
+</html>
+{{ end }}
+
+{{ define "index" }}{{ template "head" . }}
+{{ range .Spaces }}<h1>{{ .Title }}</h1>
+{{ template "tree" .Children }}
+{{ end }}
+</body>
+</html>
+{{ end }}
+`))
+
+// exportedPage is a page of exported site. Pages which are only listed in
+// Parent headers have no source file and list their children only.
+type exportedPage struct {
+	space string
+	title string
+	file  string
+
+	parent   *exportedPage
+	children []*exportedPage
+
+	// path is a path of HTML file relative to output directory.
+	path string
+
+	// id is an anchor of the page in PDF document.
+	id string
+}
+
+// exportSection is a page in the document which is converted into PDF.
+type exportSection struct {
+	ID      string
+	Title   string
+	Parents []exportLink
+	Body    template.HTML
+}
+
+// exportLink is a link to the page from another page.
+type exportLink struct {
+	Title    string
+	Link     string
+	Children []exportLink
+}
+
+// exporter writes markdown files as static site, where pages are placed
+// according to their Space and Parent headers.
+type exporter struct {
+	out string
+
+	// treeRoot is a directory which parents of pages are derived from if
+	// it's specified, see mark.SetTreeParents.
+	treeRoot string
+
+	pages map[string]*exportedPage
+}
+
+// exportPages compiles markdown files into HTML pages of static site in
+// given directory. Pages are linked according to Parent headers, and
+// attachments are copied next to pages. It doesn't need Confluence, so
+// the site is an approximation of pages in Confluence, see render.HTML.
+// In PDF format pages of every space are written into single document
+// instead, which is converted into PDF by external converter.
+func exportPages(
+	target string,
+	format string,
+	out string,
+	treeRoot string,
+) error {
+	var converter []string
+
+	switch format {
+	case ExportHTML:
+
+	case ExportPDF:
+		var err error
+
+		converter, err = getPDFConverter()
+		if err != nil {
+			return fail(ExitConfig, err)
+		}
+
+	default:
+		return fail(ExitConfig, fmt.Errorf(
+			"unsupported export format: %q",
+			format,
+		))
+	}
+
+	files, err := resolveFiles(target)
+	if err != nil {
+		return fail(ExitSource, err)
+	}
+
+	exporter := &exporter{
+		out:      out,
+		treeRoot: treeRoot,
+		pages:    map[string]*exportedPage{},
+	}
+
+	for _, file := range files {
+		err := exporter.addFile(file)
+		if err != nil {
+			return err
+		}
+	}
+
+	spaces := exporter.getSpaces()
+
+	for _, space := range spaces {
+		exporter.setPaths(space.children, getSlug(space.title))
+	}
+
+	if format == ExportPDF {
+		return exporter.writeDocuments(spaces, converter)
+	}
+
+	for _, space := range spaces {
+		err := exporter.walk(space.children, exporter.writePage)
+		if err != nil {
+			return err
+		}
+	}
+
+	index := struct {
+		Title      string
+		Stylesheet template.CSS
+		Spaces     []exportLink
+	}{
+		Title:      "Pages",
+		Stylesheet: template.CSS(render.Stylesheet),
+	}
+
+	for _, space := range spaces {
+		index.Spaces = append(index.Spaces, exportLink{
+			Title:    space.title,
+			Children: exporter.getLinks("index.html", space.children),
+		})
+	}
+
+	err = exporter.write("index.html", "index", index)
+	if err != nil {
+		return err
+	}
+
+	log.Infof(
+		nil,
+		"%d pages are exported into %q",
+		len(exporter.pages),
+		filepath.Join(out, "index.html"),
+	)
+
+	return nil
+}
+
+// addFile adds page of the file and pages from its Parent headers into the
+// tree of pages.
+func (exporter *exporter) addFile(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fail(ExitSource, err)
+	}
+
+	meta, _, err := mark.ExtractMeta(file, data)
+	if err != nil {
+		return fail(ExitSource, err)
+	}
+
+	if meta == nil {
+		log.Warningf(nil, "%s: file has no headers, it is not exported", file)
+
+		return nil
+	}
+
+	err = setTreeParents(exporter.treeRoot, file, meta)
+	if err != nil {
+		return err
+	}
+
+	var parent *exportedPage
+
+	for _, title := range append(meta.Parents, meta.Title) {
+		page := exporter.getPage(meta.Space, title)
+
+		if parent != nil && page.parent == nil && !page.isAncestorOf(parent) {
+			page.parent = parent
+			parent.children = append(parent.children, page)
+		}
+
+		parent = page
+	}
+
+	page := parent
+
+	if page.file != "" {
+		log.Warningf(
+			nil,
+			"%s: page %q is already exported from %q, file is skipped",
+			file,
+			meta.Title,
+			page.file,
+		)
+
+		return nil
+	}
+
+	page.file = file
+
+	return nil
+}
+
+func (exporter *exporter) getPage(space string, title string) *exportedPage {
+	key := space + "\n" + title
+
+	page, ok := exporter.pages[key]
+	if !ok {
+		page = &exportedPage{space: space, title: title}
+
+		exporter.pages[key] = page
+	}
+
+	return page
+}
+
+func (page *exportedPage) isAncestorOf(other *exportedPage) bool {
+	for ; other != nil; other = other.parent {
+		if other == page {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getSpaces returns top level pages of spaces as children of space pages,
+// which are not written themselves.
+func (exporter *exporter) getSpaces() []*exportedPage {
+	spaces := map[string]*exportedPage{}
+	for _, page := range exporter.pages {
+		if page.parent != nil {
+			continue
+		}
+
+		space, ok := spaces[page.space]
+		if !ok {
+			space = &exportedPage{title: page.space}
+
+			spaces[page.space] = space
+		}
+
+		space.children = append(space.children, page)
+	}
+
+	result := []*exportedPage{}
+	for _, space := range spaces {
+		result = append(result, space)
+	}
+
+	sortPages(result)
+
+	for _, page := range exporter.pages {
+		sortPages(page.children)
+	}
+
+	for _, space := range result {
+		sortPages(space.children)
+	}
+
+	return result
+}
+
+// setPaths places pages into given directory. Page with children is written
+// into index.html in directory named after the page.
+func (exporter *exporter) setPaths(pages []*exportedPage, dir string) {
+	taken := map[string]bool{}
+
+	for _, page := range pages {
+		name := getSlug(page.title)
+
+		slug := name
+		for i := 2; taken[slug]; i++ {
+			slug = fmt.Sprintf("%s-%d", name, i)
+		}
+
+		taken[slug] = true
+
+		if len(page.children) == 0 {
+			page.path = filepath.Join(dir, slug+".html")
+
+			continue
+		}
+
+		page.path = filepath.Join(dir, slug, "index.html")
+
+		exporter.setPaths(page.children, filepath.Join(dir, slug))
+	}
+}
+
+func (exporter *exporter) walk(
+	pages []*exportedPage,
+	callback func(*exportedPage) error,
+) error {
+	for _, page := range pages {
+		err := callback(page)
+		if err != nil {
+			return err
+		}
+
+		err = exporter.walk(page.children, callback)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeDocuments writes pages of every space into HTML document, which is
+// converted into PDF file named after the space. Documents and attachments
+// are written into temporary directory, so only PDF files are left in the
+// output directory.
+func (exporter *exporter) writeDocuments(
+	spaces []*exportedPage,
+	converter []string,
+) error {
+	out := exporter.out
+
+	dir, err := ioutil.TempDir("", "mark-export-")
+	if err != nil {
+		return karma.Format(err, "unable to create temporary directory")
+	}
+
+	defer os.RemoveAll(dir)
+
+	exporter.out = dir
+
+	defer func() {
+		exporter.out = out
+	}()
+
+	err = os.MkdirAll(out, 0755)
+	if err != nil {
+		return karma.Format(err, "unable to create directory")
+	}
+
+	for _, space := range spaces {
+		name := getSlug(space.title)
+
+		err := exporter.writeDocument(space, name+".html")
+		if err != nil {
+			return err
+		}
+
+		pdf, err := filepath.Abs(filepath.Join(out, name+".pdf"))
+		if err != nil {
+			return err
+		}
+
+		err = convertPDF(converter, filepath.Join(dir, name+".html"), pdf)
+		if err != nil {
+			return fail(ExitFailure, err)
+		}
+
+		log.Infof(nil, "space %q is exported into %q", space.title, pdf)
+	}
+
+	return nil
+}
+
+// writeDocument writes all pages of the space into single HTML document in
+// order of the page tree. Links between pages point to their anchors in the
+// document.
+func (exporter *exporter) writeDocument(
+	space *exportedPage,
+	path string,
+) error {
+	id := 0
+	exporter.walk(space.children, func(page *exportedPage) error {
+		id++
+		page.id = fmt.Sprintf("page-%d", id)
+
+		return nil
+	})
+
+	data := struct {
+		Title      string
+		Stylesheet template.CSS
+		Children   []exportLink
+		Pages      []exportSection
+	}{
+		Title:      space.title,
+		Stylesheet: template.CSS(render.Stylesheet),
+		Children:   getAnchorLinks(space.children),
+	}
+
+	err := exporter.walk(space.children, func(page *exportedPage) error {
+		section := exportSection{
+			ID:    page.id,
+			Title: page.title,
+		}
+
+		for parent := page.parent; parent != nil; parent = parent.parent {
+			section.Parents = append([]exportLink{{
+				Title: parent.title,
+				Link:  "#" + parent.id,
+			}}, section.Parents...)
+		}
+
+		body, err := exporter.renderPage(page, ".")
+		if err != nil {
+			return err
+		}
+
+		section.Body = body
+
+		data.Pages = append(data.Pages, section)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return exporter.write(path, "document", data)
+}
+
+func (exporter *exporter) writePage(page *exportedPage) error {
+	data := struct {
+		Title      string
+		Stylesheet template.CSS
+		Space      string
+		Index      string
+		Parents    []exportLink
+		Body       template.HTML
+		Children   []exportLink
+	}{
+		Title:      page.title,
+		Stylesheet: template.CSS(render.Stylesheet),
+		Space:      page.space,
+		Index:      getRelativeLink(page.path, "index.html"),
+		Children:   exporter.getLinks(page.path, page.children),
+	}
+
+	for parent := page.parent; parent != nil; parent = parent.parent {
+		data.Parents = append([]exportLink{{
+			Title: parent.title,
+			Link:  getRelativeLink(page.path, parent.path),
+		}}, data.Parents...)
+	}
+
+	body, err := exporter.renderPage(page, filepath.Dir(page.path))
+	if err != nil {
+		return err
+	}
+
+	data.Body = body
+
+	return exporter.write(page.path, "page", data)
+}
+
+// renderPage compiles source file of the page and copies its attachments
+// next to the page. Links to attachments are relative to given directory.
+// Pages which have no source file have empty body.
+func (exporter *exporter) renderPage(
+	page *exportedPage,
+	dir string,
+) (template.HTML, error) {
+	if page.file == "" {
+		return "", nil
+	}
+
+	// attachments of index.html are named after directory of the page
+	name := strings.TrimSuffix(filepath.Base(page.path), ".html")
+	if name == "index" {
+		name = filepath.Base(filepath.Dir(page.path))
+	}
+
+	attachments := filepath.Join(filepath.Dir(page.path), "attachments", name)
+
+	log.Infof(nil, "exporting %q into %q", page.file, page.path)
+
+	link, err := filepath.Rel(dir, attachments)
+	if err != nil {
+		link = attachments
+	}
+
+	rendered, err := renderFile(page.file, filepath.ToSlash(link)+"/")
+	if err != nil {
+		return "", fail(ExitSource, karma.Format(
+			err,
+			"unable to export %q",
+			page.file,
+		))
+	}
+
+	for name, path := range rendered.attachments {
+		err := copyFile(
+			path,
+			filepath.Join(exporter.out, attachments, name),
+		)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return template.HTML(rendered.html), nil
+}
+
+func (exporter *exporter) write(
+	path string,
+	name string,
+	data interface{},
+) error {
+	path = filepath.Join(exporter.out, path)
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return karma.Format(err, "unable to create directory")
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return karma.Format(err, "unable to create file %q", path)
+	}
+
+	defer file.Close()
+
+	err = exportTemplates.ExecuteTemplate(file, name, data)
+	if err != nil {
+		return karma.Format(err, "unable to write file %q", path)
+	}
+
+	return nil
+}
+
+// getLinks returns links to given pages and their descendants from the page
+// at given path.
+func (exporter *exporter) getLinks(
+	from string,
+	pages []*exportedPage,
+) []exportLink {
+	links := []exportLink{}
+	for _, page := range pages {
+		links = append(links, exportLink{
+			Title:    page.title,
+			Link:     getRelativeLink(from, page.path),
+			Children: exporter.getLinks(from, page.children),
+		})
+	}
+
+	return links
+}
+
+// getAnchorLinks returns links to anchors of given pages and their
+// descendants in PDF document.
+func getAnchorLinks(pages []*exportedPage) []exportLink {
+	links := []exportLink{}
+	for _, page := range pages {
+		links = append(links, exportLink{
+			Title:    page.title,
+			Link:     "#" + page.id,
+			Children: getAnchorLinks(page.children),
+		})
+	}
+
+	return links
+}
+
+// getPDFConverter returns command of the first PDF converter found in PATH.
+func getPDFConverter() ([]string, error) {
+	names := []string{}
+	for _, converter := range pdfConverters {
+		_, err := exec.LookPath(converter[0])
+		if err == nil {
+			return converter, nil
+		}
+
+		names = append(names, converter[0])
+	}
+
+	return nil, fmt.Errorf(
+		"export into pdf requires one of converters to be installed: %s",
+		strings.Join(names, ", "),
+	)
+}
+
+// convertPDF runs converter to convert HTML file into PDF file.
+func convertPDF(converter []string, input string, output string) error {
+	replacer := strings.NewReplacer("{input}", input, "{output}", output)
+
+	args := []string{}
+	for _, arg := range converter[1:] {
+		args = append(args, replacer.Replace(arg))
+	}
+
+	log.Debugf(nil, "converting %q into pdf: %s %v", input, converter[0], args)
+
+	cmd := exec.Command(converter[0], args...)
+
+	message, err := cmd.CombinedOutput()
+	if err != nil {
+		return karma.Describe("output", strings.TrimSpace(string(message))).
+			Format(err, "unable to convert %q into pdf", input)
+	}
+
+	_, err = os.Stat(output)
+	if err != nil {
+		return karma.Format(err, "converter %q produced no pdf", converter[0])
+	}
+
+	return nil
+}
+
+func sortPages(pages []*exportedPage) {
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].title < pages[j].title
+	})
+}
+
+// getRelativeLink returns URL of file at given path relative to the file at
+// path from, both paths are relative to the same directory.
+func getRelativeLink(from string, path string) string {
+	link, err := filepath.Rel(filepath.Dir(from), path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+
+	return filepath.ToSlash(link)
+}
+
+func copyFile(source string, target string) error {
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return karma.Format(err, "unable to create directory")
+	}
+
+	reader, err := os.Open(source)
+	if err != nil {
+		return karma.Format(err, "unable to open file %q", source)
+	}
+
+	defer reader.Close()
+
+	writer, err := os.Create(target)
+	if err != nil {
+		return karma.Format(err, "unable to create file %q", target)
+	}
+
+	defer writer.Close()
+
+	_, err = io.Copy(writer, reader)
+	if err != nil {
+		return karma.Format(err, "unable to copy %q to %q", source, target)
+	}
+
+	return nil
+}
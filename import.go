@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/kovetskiy/mark/pkg/confluence"
+	"github.com/kovetskiy/mark/pkg/log"
+	"github.com/kovetskiy/mark/pkg/mark"
+	"github.com/kovetskiy/mark/pkg/mark/render"
+	"github.com/reconquest/karma-go"
+)
+
+// importer converts pages of Confluence space into markdown files.
+type importer struct {
+	api   confluence.Client
+	space string
+
+	// home is a home page of the space, which is not listed in Parent
+	// headers.
+	home *confluence.PageInfo
+
+	// files are paths of markdown files and directories of pages, which are
+	// already taken.
+	files map[string]bool
+
+	// pages is a number of imported pages.
+	pages int
+}
+
+// importPages converts page with given title, or home page of the space if
+// title is empty, and all its descendants into markdown files in given
+// directory. Pages with children are written into index.md file in directory
+// named after the page. Attachments are downloaded into attachments
+// directory next to markdown file.
+func importPages(
+	api confluence.Client,
+	space string,
+	root string,
+	out string,
+) error {
+	home, err := api.FindRootPage(space)
+	if err != nil {
+		return fail(ExitPublish, karma.Format(
+			err,
+			"unable to find home page of space %q",
+			space,
+		))
+	}
+
+	importer := &importer{
+		api:   api,
+		space: space,
+		home:  home,
+		files: map[string]bool{},
+	}
+
+	var (
+		page    = home
+		parents = []string{}
+	)
+
+	if root != "" {
+		page, err = api.FindPage(space, root)
+		if err != nil {
+			return fail(ExitPublish, karma.Format(
+				err,
+				"unable to find page %q",
+				root,
+			))
+		}
+
+		if page == nil {
+			return fail(ExitConfig, fmt.Errorf(
+				"page %q is not found in space %q",
+				root,
+				space,
+			))
+		}
+
+		parents = importer.getParents(page.Ancestors)
+	}
+
+	err = importer.importPage(page, parents, out)
+	if err != nil {
+		return err
+	}
+
+	log.Infof(nil, "%d pages are imported into %q", importer.pages, out)
+
+	return nil
+}
+
+// getParents returns titles of ancestors below home page of the space.
+func (importer *importer) getParents(
+	ancestors []confluence.PageAncestor,
+) []string {
+	parents := []string{}
+	for _, ancestor := range ancestors {
+		if ancestor.Id == importer.home.ID {
+			parents = []string{}
+
+			continue
+		}
+
+		parents = append(parents, ancestor.Title)
+	}
+
+	return parents
+}
+
+func (importer *importer) importPage(
+	page *confluence.PageInfo,
+	parents []string,
+	dir string,
+) error {
+	children, err := importer.api.GetChildPages(page.ID)
+	if err != nil {
+		return fail(ExitPublish, karma.Format(
+			err,
+			"unable to get child pages of %q",
+			page.Title,
+		))
+	}
+
+	// home page is the root of the space, so it is written into index.md of
+	// output directory itself
+	file := filepath.Join(dir, "index.md")
+	if page.ID != importer.home.ID {
+		file = importer.getFile(page.Title, dir, len(children) > 0)
+	}
+
+	// import should never destroy files which are already in repository
+	if _, err := os.Stat(file); err == nil {
+		return fail(ExitConfig, fmt.Errorf(
+			"file %q already exists, refusing to overwrite it",
+			file,
+		))
+	}
+
+	log.Infof(nil, "importing page %q into %q", page.Title, file)
+
+	attachments, err := importer.downloadAttachments(page, file)
+	if err != nil {
+		return err
+	}
+
+	body, err := importer.api.GetPageBody(page.ID, 0)
+	if err != nil {
+		return fail(ExitPublish, karma.Format(
+			err,
+			"unable to get content of page %q",
+			page.Title,
+		))
+	}
+
+	document, err := render.Markdown(body, func(name string) string {
+		if path, ok := attachments[name]; ok {
+			return path
+		}
+
+		return name
+	})
+	if err != nil {
+		return fail(ExitPublish, karma.Format(
+			err,
+			"unable to convert page %q into markdown",
+			page.Title,
+		))
+	}
+
+	var buffer bytes.Buffer
+
+	header := func(name string, value string) {
+		fmt.Fprintf(&buffer, "<!-- %s: %s -->\n", name, value)
+	}
+
+	header(mark.HeaderSpace, importer.space)
+
+	for _, parent := range parents {
+		header(mark.HeaderParent, parent)
+	}
+
+	header(mark.HeaderTitle, page.Title)
+
+	if document.Layout != "" {
+		header(mark.HeaderLayout, document.Layout)
+	}
+
+	// attachments are uploaded under their original names, alias is
+	// written only if the name differs from the one made of the path
+	for _, name := range getSortedKeys(attachments) {
+		path := attachments[name]
+		if mark.GetAttachmentFilename(path) != name {
+			path += " as " + name
+		}
+
+		header(mark.HeaderAttachment, path)
+	}
+
+	buffer.WriteString("\n")
+	buffer.WriteString(document.Markdown)
+
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return karma.Format(err, "unable to create directory")
+	}
+
+	err = ioutil.WriteFile(file, buffer.Bytes(), 0644)
+	if err != nil {
+		return karma.Format(err, "unable to write file %q", file)
+	}
+
+	importer.pages++
+
+	// home page is not listed in Parent headers
+	if page.ID != importer.home.ID {
+		parents = append(append([]string{}, parents...), page.Title)
+	}
+
+	for i := range children {
+		err := importer.importPage(&children[i], parents, filepath.Dir(file))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getFile returns path of markdown file for the page with given title. Page
+// with children is written into index.md in directory named after the page.
+func (importer *importer) getFile(
+	title string,
+	dir string,
+	children bool,
+) string {
+	name := getSlug(title)
+
+	for i := 1; ; i++ {
+		slug := name
+		if i > 1 {
+			slug = fmt.Sprintf("%s-%d", name, i)
+		}
+
+		file := filepath.Join(dir, slug+".md")
+		if children {
+			file = filepath.Join(dir, slug, "index.md")
+		}
+
+		// pages with the same slug are written to different files
+		if importer.files[file] || importer.files[filepath.Join(dir, slug)] {
+			continue
+		}
+
+		importer.files[file] = true
+		importer.files[filepath.Join(dir, slug)] = true
+
+		return file
+	}
+}
+
+// downloadAttachments downloads attachments of the page into attachments
+// directory next to given markdown file and returns their local paths
+// relative to markdown file by names.
+func (importer *importer) downloadAttachments(
+	page *confluence.PageInfo,
+	file string,
+) (map[string]string, error) {
+	infos, err := importer.api.GetAttachments(page.ID)
+	if err != nil {
+		return nil, fail(ExitPublish, karma.Format(
+			err,
+			"unable to get attachments of page %q",
+			page.Title,
+		))
+	}
+
+	// attachments of index.md are named after directory of the page
+	name := strings.TrimSuffix(filepath.Base(file), ".md")
+	if name == "index" {
+		name = filepath.Base(filepath.Dir(file))
+	}
+
+	var (
+		paths = map[string]string{}
+		dir   = filepath.Join("attachments", name)
+	)
+
+	for _, info := range infos {
+		path := filepath.Join(dir, info.Filename)
+		target := filepath.Join(filepath.Dir(file), path)
+
+		log.Infof(nil, "downloading attachment %q into %q", info.Filename, target)
+
+		err := os.MkdirAll(filepath.Dir(target), 0755)
+		if err != nil {
+			return nil, karma.Format(err, "unable to create directory")
+		}
+
+		writer, err := os.OpenFile(
+			target,
+			os.O_WRONLY|os.O_CREATE|os.O_EXCL,
+			0644,
+		)
+		if os.IsExist(err) {
+			return nil, fail(ExitConfig, fmt.Errorf(
+				"file %q already exists, refusing to overwrite it",
+				target,
+			))
+		}
+
+		if err != nil {
+			return nil, karma.Format(err, "unable to create file %q", target)
+		}
+
+		err = importer.api.DownloadAttachment(info, writer)
+
+		writer.Close()
+
+		if err != nil {
+			return nil, fail(ExitPublish, err)
+		}
+
+		paths[info.Filename] = filepath.ToSlash(path)
+	}
+
+	return paths, nil
+}
+
+// getSlug converts page title into file name: letters and digits are kept
+// in lower case, everything else is replaced with dashes.
+func getSlug(title string) string {
+	slug := strings.Map(
+		func(symbol rune) rune {
+			if unicode.IsLetter(symbol) || unicode.IsDigit(symbol) {
+				return unicode.ToLower(symbol)
+			}
+
+			return '-'
+		},
+		title,
+	)
+
+	for strings.Contains(slug, "--") {
+		slug = strings.ReplaceAll(slug, "--", "-")
+	}
+
+	slug = strings.Trim(slug, "-")
+	if slug == "" {
+		return "page"
+	}
+
+	return slug
+}
+
+func getSortedKeys(values map[string]string) []string {
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kovetskiy/mark/pkg/log"
+	"github.com/kovetskiy/mark/pkg/mark"
+	"github.com/reconquest/karma-go"
+)
+
+// checkWarnings fails in strict mode if any warnings were logged while
+// processing current file.
+func checkWarnings(strict bool) error {
+	if !strict {
+		return nil
+	}
+
+	warnings := log.GetWarnings()
+	if len(warnings) == 0 {
+		return nil
+	}
+
+	reasons := []karma.Reason{}
+	for _, warning := range warnings {
+		reasons = append(reasons, warning)
+	}
+
+	return fail(
+		ExitSource,
+		karma.Push("warnings are treated as errors in strict mode", reasons...),
+	)
+}
+
+// Diagnostic is a problem found by lint in the file. Line is zero if
+// position of the problem is unknown.
+type Diagnostic struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (diagnostic Diagnostic) String() string {
+	if diagnostic.Line == 0 {
+		return fmt.Sprintf("%s: %s", diagnostic.File, diagnostic.Message)
+	}
+
+	return fmt.Sprintf(
+		"%s:%d: %s",
+		diagnostic.File,
+		diagnostic.Line,
+		diagnostic.Message,
+	)
+}
+
+// lint checks given files without connecting to Confluence and prints found
+// problems. Parents of pages are derived from directory layout under given
+// tree root like when publishing, if it's not empty. It returns exit code of
+// the program.
+func lint(paths []string, treeRoot string) int {
+	files := []string{}
+	for _, path := range paths {
+		found, err := resolveFiles(path)
+		if err != nil {
+			log.Errorf(err, "unable to find files to check")
+
+			return ExitSource
+		}
+
+		files = append(files, found...)
+	}
+
+	failed := 0
+
+	for _, file := range files {
+		diagnostics := lintFile(file, treeRoot)
+		for _, diagnostic := range diagnostics {
+			fmt.Println(diagnostic)
+		}
+
+		if len(diagnostics) > 0 {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		log.Errorf(
+			nil,
+			"%d of %d files have problems",
+			failed,
+			len(files),
+		)
+
+		return ExitSource
+	}
+
+	log.Infof(nil, "%d files checked, no problems found", len(files))
+
+	return ExitSuccess
+}
+
+// lintFile runs all steps of publishing which don't require Confluence and
+// returns errors and warnings found in the file. Users in templates are not
+// resolved, because it requires Confluence.
+func lintFile(file string, treeRoot string) []Diagnostic {
+	// warnings logged before belong to other files
+	log.TakeWarnings()
+
+	// warnings are printed as diagnostics
+	log.SetWarningsQuiet(true)
+	defer log.SetWarningsQuiet(false)
+
+	diagnostics := []Diagnostic{}
+
+	add := func(line int, message string) {
+		if line == 0 {
+			line, message = splitPosition(file, message)
+		}
+
+		diagnostics = append(diagnostics, Diagnostic{
+			File:    file,
+			Line:    line,
+			Message: message,
+		})
+	}
+
+	report := func() []Diagnostic {
+		for _, warning := range log.TakeWarnings() {
+			add(0, warning)
+		}
+
+		return diagnostics
+	}
+
+	source, err := parseSource(nil, file, func(err error) error {
+		for _, problem := range splitErrors(err) {
+			add(0, problem.Error())
+		}
+
+		return nil
+	})
+	if err != nil {
+		add(0, err.Error())
+
+		return report()
+	}
+
+	// file without headers can be published with -l flag, which specifies
+	// the page, so it's not a problem itself
+	meta := source.meta
+	if meta == nil {
+		meta = &mark.Meta{}
+	}
+
+	err = setTreeParents(treeRoot, file, source.meta)
+	if err != nil {
+		add(0, err.Error())
+	}
+
+	attaches, err := mark.ExpandAttachments(
+		filepath.Dir(file),
+		meta.Attachments,
+	)
+	if err != nil {
+		add(0, err.Error())
+	}
+
+	for i, attach := range attaches {
+		_, err := os.Stat(attach.Path)
+		if err != nil {
+			add(
+				attach.Position.Line,
+				fmt.Sprintf("attachment %q is not found", attach.Name),
+			)
+		}
+
+		attaches[i].Link = attach.Filename
+	}
+
+	markdown := mark.CompileAttachmentLinks(source.markdown, attaches)
+
+	html := mark.CompileMarkdown(markdown, source.stdlib)
+
+	err = mark.ValidateStorage(html)
+	if err != nil {
+		line := 0
+		if storage, ok := err.(mark.StorageError); ok {
+			line = source.findPosition(html, storage).Line
+		}
+
+		add(line, err.Error())
+	}
+
+	err = source.stdlib.Templates.ExecuteTemplate(
+		&bytes.Buffer{},
+		"ac:layout",
+		struct {
+			Layout string
+			Body   string
+		}{
+			Layout: meta.Layout,
+			Body:   html,
+		},
+	)
+	if err != nil {
+		add(0, err.Error())
+	}
+
+	return report()
+}
+
+// splitErrors returns errors which are joined by position.JoinErrors, so
+// every one of them is reported as separate problem.
+func splitErrors(err error) []error {
+	joined, ok := err.(karma.Karma)
+	if !ok || len(joined.GetReasons()) < 2 {
+		return []error{err}
+	}
+
+	errs := []error{}
+	for _, reason := range joined.GetReasons() {
+		if err, ok := reason.(error); ok {
+			errs = append(errs, splitErrors(err)...)
+		} else {
+			errs = append(errs, fmt.Errorf("%s", reason))
+		}
+	}
+
+	return errs
+}
+
+// splitPosition extracts line number from the message which is prefixed with
+// position in the file, like errors of headers and directives are.
+func splitPosition(file string, message string) (int, string) {
+	prefix := file + ":"
+	if !strings.HasPrefix(message, prefix) {
+		return 0, message
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(message, prefix), ": ", 2)
+	if len(parts) != 2 {
+		return 0, message
+	}
+
+	line, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, message
+	}
+
+	return line, parts[1]
+}
@@ -2,18 +2,18 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/docopt/docopt-go"
 	"github.com/kovetskiy/mark/pkg/confluence"
 	"github.com/kovetskiy/mark/pkg/log"
 	"github.com/kovetskiy/mark/pkg/mark"
-	"github.com/kovetskiy/mark/pkg/mark/includes"
-	"github.com/kovetskiy/mark/pkg/mark/macro"
-	"github.com/kovetskiy/mark/pkg/mark/stdlib"
 	"github.com/reconquest/karma-go"
 )
 
@@ -48,14 +48,59 @@ File in extended format should follow specification:
 There can be any number of 'Parent' headers, if mark can't find specified
 parent by title, it will be created.
 
+Mark remembers which file the page is published from, so when 'Title' or
+'Parent' headers are changed, existing page is renamed or moved accordingly.
+
 Also, optional following headers are supported:
 
+  * <!-- ID: <page id> -->
+
+    Page with given ID is updated (and renamed or moved if needed) instead
+    of looking it up by title. Use --write-back flag to add this header
+    automatically after the page is created.
+
+  * <!-- Type: (page|blogpost) -->
+
+    - (default) page: content is published as a regular page;
+    - blogpost: content is published as a blog post, 'Parent' headers are
+      ignored;
+
+  * <!-- Date: <YYYY-MM-DD> -->
+
+    Posting day of blog post, which is used along with the title to find
+    existing blog post.
+
   * <!-- Layout: (article|plain) -->
 
     - (default) article: content will be put in narrow column for ease of
       reading;
     - plain: content will fill all page;
 
+  * <!-- Attachment: <path> [as <name>] -->
+
+    File to attach to the page. Path is relative to markdown file directory,
+    it can be a glob pattern (images/*.png) or a directory, which is
+    attached recursively. Optional 'as <name>' sets name of the attachment
+    in Confluence.
+
+  * <!-- Attachment-Comment: <comment> -->
+
+    Comment which is set to uploaded attachments. By default subject of the
+    last git commit which changed attachment file is used.
+
+  * <!-- Appearance: (full-width|fixed) -->
+
+    Page width in Confluence Cloud editor.
+
+  * <!-- Emoji: <emoji> -->
+
+    Emoji which is shown in front of page title, either emoji itself or its
+    hex code (like 1f680).
+
+  * <!-- Cover: <url> -->
+
+    URL of the picture to show as page cover (Confluence Cloud only).
+
 Mark supports Go templates, which can be included into article by using path
 to the template relative to current working dir, e.g.:
 
@@ -104,10 +149,20 @@ By default, mark provides several built-in templates and macros:
 
 * macro '@{...}' to mention user by name specified in the braces.
 
+When directory is published, mark can remove pages which source files were
+removed. To enable it, label topmost managed page with 'mark-root' label and
+run mark with --prune flag to see which pages will be removed, then add
+--prune-confirm flag to actually remove them.
+
 Usage:
   mark [options] [-u <username>] [-p <token>] [-k] [-l <url>] -f <file>
   mark [options] [-u <username>] [-p <password>] [-k] [-b <url>] -f <file>
   mark [options] [-u <username>] [-p <password>] [-k] [-n] -c <file>
+  mark lint [options] <path>...
+  mark preview [options] -f <file>
+  mark pull [options] [-u <username>] [-p <password>] [-b <url>] [--merge] -f <file>
+  mark export [options] -f <file> --out <dir>
+  mark import [options] [-u <username>] [-p <password>] [-b <url>] --space <key> --out <dir>
   mark -v | --version
   mark -h | --help
 
@@ -119,15 +174,76 @@ Options:
                         above).
   -b --base-url <url>  Base URL for Confluence.
                         Alternative option for base_url config field.
+  --api-version <ver>  Confluence REST API version to use: v1, v2 or auto.
+                        In auto mode v2 is used for Confluence Cloud
+                        (*.atlassian.net) and v1 otherwise.
+                        [default: auto]
   -f <file>            Use specified markdown file for converting to html.
+                        If directory is specified, all *.md files in it are
+                        processed recursively. Glob patterns are supported
+                        as well (should be quoted).
   -k                   Lock page editing to current user only to prevent accidental
                         manual edits over Confluence Web UI.
   --dry-run            Resolve page and ancestry, show resulting HTML and exit.
   --compile-only       Show resulting HTML and don't update Confluence page content.
+  --write-back         Add ID header with ID of published page to the file
+                        if it has no such header yet.
+  --prune              List pages under managed roots which do not correspond
+                        to any processed file. Managed root is a parent page
+                        labelled with 'mark-root' label. Only pages under
+                        parents of processed pages are listed.
+  --prune-confirm      Remove pages listed by --prune.
+  --prune-archive      Archive pages listed by --prune instead of moving them
+                        to trash (Confluence Cloud only).
+  --prune-attachments  Move attachments which were uploaded by mark, but are
+                        not used by the page anymore, to trash.
+  --max-attachment-size <size>
+                       Refuse to upload attachments larger than specified size.
+                        Size can have K, M or G suffix. Zero means no limit.
+                        [default: 0]
+  --output <format>    Output format: text (print URL of every page) or json
+                        (print JSON record about every processed file).
+                        [default: text]
+  --strict             Treat warnings, like unknown headers, unused attachments
+                        or missing parent pages, as errors.
+  --report <file>      Write summary of processed files to specified file:
+                        JUnit XML if file has .xml extension and Markdown
+                        otherwise.
+  --watch              Keep running after files are processed and republish
+                        files when they, templates they include or their
+                        attachments are changed. New files in specified
+                        directory are published as well.
+  --listen <address>   Address to serve preview at.
+                        [default: 127.0.0.1:8080]
+  --merge              Merge changes made in Confluence since the last
+                        publication into the file instead of overwriting its
+                        content. Conflicting changes are surrounded by
+                        conflict markers like in git.
+  --space <key>        Space to import pages from.
+  --root <title>       Import only specified page and its descendants instead
+                        of the whole space.
+  --out <dir>          Directory to write imported markdown files or exported
+                        pages to.
+  --tree-root <dir>    Derive Parent headers of pages from directories between
+                        specified directory and the file: docs/ops/db.md gets
+                        parent "Ops" if docs is specified. Title of directory
+                        is taken from index.md or _meta.yml in the directory.
+                        Parent headers in files take precedence.
+  --format <format>    Export format: html (static site with pages linked
+                        according to Parent headers) or pdf (PDF document per
+                        space, requires wkhtmltopdf or chromium).
+                        [default: html]
   --debug              Enable debug logs.
   --trace              Enable trace logs.
   -h --help            Show this screen and call 911.
   -v --version         Show version.
+
+Exit codes:
+  0  All files are processed successfully.
+  1  Unexpected error.
+  2  Invalid options, configuration or credentials.
+  3  Invalid source file: headers, includes, macros or templates.
+  4  Publishing failed: Confluence API error or attachment upload error.
 `
 )
 
@@ -139,84 +255,445 @@ func main() {
 
 	var (
 		targetFile, _ = args["-f"].(string)
-		compileOnly   = args["--compile-only"].(bool)
 		dryRun        = args["--dry-run"].(bool)
-		editLock      = args["-k"].(bool)
+		prune         = args["--prune"].(bool)
+		pruneConfirm  = args["--prune-confirm"].(bool)
+		pruneArchive  = args["--prune-archive"].(bool)
+		output        = args["--output"].(string)
+		reportFile, _ = args["--report"].(string)
+		watch         = args["--watch"].(bool)
 	)
 
 	log.Init(args["--debug"].(bool), args["--trace"].(bool))
 
+	if args["lint"].(bool) {
+		treeRoot, _ := args["--tree-root"].(string)
+
+		os.Exit(lint(args["<path>"].([]string), treeRoot))
+	}
+
+	if args["preview"].(bool) {
+		os.Exit(preview(targetFile, args["--listen"].(string)))
+	}
+
+	if args["export"].(bool) {
+		treeRoot, _ := args["--tree-root"].(string)
+
+		err := exportPages(
+			targetFile,
+			args["--format"].(string),
+			args["--out"].(string),
+			treeRoot,
+		)
+		if err != nil {
+			fatalf(getExitCode(err), err, "unable to export pages")
+		}
+
+		os.Exit(ExitSuccess)
+	}
+
+	if output != OutputText && output != OutputJSON {
+		fatalf(ExitConfig, nil, "unsupported output format: %q", output)
+	}
+
 	config, err := LoadConfig(filepath.Join(os.Getenv("HOME"), ".config/mark"))
 	if err != nil {
-		log.Fatal(err)
+		fatalf(ExitConfig, err, "unable to load configuration")
 	}
 
 	creds, err := GetCredentials(args, config)
 	if err != nil {
-		log.Fatal(err)
+		fatalf(ExitConfig, err, "unable to get credentials")
 	}
 
-	api := confluence.NewAPI(creds.BaseURL, creds.Username, creds.Password)
-
-	markdown, err := ioutil.ReadFile(targetFile)
+	api, err := confluence.NewClient(
+		creds.BaseURL,
+		creds.Username,
+		creds.Password,
+		args["--api-version"].(string),
+	)
 	if err != nil {
-		log.Fatal(err)
+		fatalf(ExitConfig, err, "unable to create Confluence client")
 	}
 
-	meta, markdown, err := mark.ExtractMeta(markdown)
-	if err != nil {
-		log.Fatal(err)
+	if args["pull"].(bool) {
+		treeRoot, _ := args["--tree-root"].(string)
+
+		err := pullFile(
+			api,
+			creds,
+			targetFile,
+			args["--merge"].(bool),
+			dryRun,
+			treeRoot,
+		)
+		if err != nil {
+			fatalf(getExitCode(err), err, "unable to pull page")
+		}
+
+		os.Exit(ExitSuccess)
+	}
+
+	if args["import"].(bool) {
+		root, _ := args["--root"].(string)
+
+		err := importPages(
+			api,
+			args["--space"].(string),
+			root,
+			args["--out"].(string),
+		)
+		if err != nil {
+			fatalf(getExitCode(err), err, "unable to import pages")
+		}
+
+		os.Exit(ExitSuccess)
 	}
 
-	stdlib, err := stdlib.New(api)
+	files, err := resolveFiles(targetFile)
 	if err != nil {
-		log.Fatal(err)
+		fatalf(ExitSource, err, "unable to find files to process")
+	}
+
+	if creds.PageID != "" && len(files) > 1 {
+		fatalf(
+			ExitConfig,
+			nil,
+			`page URL can't be specified via command line `+
+				`when multiple files are processed`,
+		)
+	}
+
+	var (
+		published = []*confluence.PageInfo{}
+		results   = []*Result{}
+		failed    = 0
+	)
+
+	report := func() {
+		if reportFile == "" {
+			return
+		}
+
+		err := writeReport(reportFile, results)
+		if err != nil {
+			log.Errorf(err, "unable to write report to %q", reportFile)
+		}
 	}
 
-	templates := stdlib.Templates
+	for _, file := range files {
+		result := newResult(file)
+
+		err := processFile(api, creds, args, file, nil, result)
+
+		result.finish(output, creds.BaseURL, err)
+
+		results = append(results, result)
+
+		if err != nil && watch {
+			log.Errorf(err, "unable to process file %q", file)
+
+			failed++
+
+			continue
+		}
+
+		if err != nil {
+			report()
+
+			fatalf(getExitCode(err), err, "unable to process file %q", file)
+		}
+
+		if result.Page != nil {
+			published = append(published, result.Page)
+		}
+	}
 
-	var recurse bool
+	report()
 
-	for {
-		templates, markdown, recurse, err = includes.ProcessIncludes(
-			markdown,
-			templates,
+	if prune && failed > 0 {
+		// pages of failed files are not resolved, so they would be
+		// considered stale and removed
+		log.Errorf(
+			nil,
+			"%d files are not processed, stale pages are not pruned",
+			failed,
 		)
+	} else if prune {
+		if len(published) == 0 {
+			fatalf(ExitFailure, nil, `no pages are resolved, nothing to prune`)
+		}
+
+		stale, err := mark.FindStalePages(api, published)
 		if err != nil {
-			log.Fatal(err)
+			fatalf(ExitPublish, err, "unable to find stale pages")
+		}
+
+		for _, page := range stale {
+			log.Infof(nil, "stale page: %s", mark.GetPagePath(page))
 		}
 
-		if !recurse {
-			break
+		if len(stale) == 0 {
+			log.Infof(nil, "no stale pages found")
+		} else if dryRun || !pruneConfirm {
+			log.Infof(
+				nil,
+				"%d stale pages found, specify --prune-confirm to remove them",
+				len(stale),
+			)
+		} else {
+			err := mark.PrunePages(api, stale, pruneArchive)
+			if err != nil {
+				fatalf(ExitPublish, err, "unable to prune pages")
+			}
+		}
+	}
+
+	if watch {
+		watchFiles(
+			targetFile,
+			results,
+			func(file string, previous *Result) *Result {
+				result := newResult(file)
+
+				err := processFile(api, creds, args, file, previous, result)
+
+				result.finish(output, creds.BaseURL, err)
+
+				if err != nil {
+					log.Errorf(err, "unable to process file %q", file)
+				}
+
+				return result
+			},
+		)
+	}
+}
+
+func resolveFiles(target string) ([]string, error) {
+	info, err := os.Stat(target)
+	if err == nil && info.IsDir() {
+		files := []string{}
+
+		err := filepath.Walk(
+			target,
+			func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+
+				if !info.IsDir() && filepath.Ext(path) == ".md" {
+					files = append(files, path)
+				}
+
+				return nil
+			},
+		)
+		if err != nil {
+			return nil, karma.Format(
+				err,
+				"unable to list markdown files in %q",
+				target,
+			)
 		}
+
+		return files, nil
+	}
+
+	files, err := filepath.Glob(target)
+	if err != nil {
+		return nil, karma.Format(
+			err,
+			"unable to match files using pattern %q",
+			target,
+		)
 	}
 
-	macros, markdown, err := macro.ExtractMacros(markdown, templates)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files matched %q", target)
+	}
+
+	return files, nil
+}
+
+func writeBackID(file string, id string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	data = mark.InsertMetaHeader(data, mark.HeaderID, id)
+
+	err = ioutil.WriteFile(file, data, info.Mode())
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	macros = append(macros, stdlib.Macros...)
+	log.Infof(nil, "page id %s is written to %q", id, file)
 
-	for _, macro := range macros {
-		markdown, err = macro.Apply(markdown)
+	return nil
+}
+
+// parseSize parses size in bytes with optional K, M or G suffix.
+func parseSize(value string) (int64, error) {
+	units := map[string]int64{
+		"K": 1 << 10,
+		"M": 1 << 20,
+		"G": 1 << 30,
+	}
+
+	value = strings.ToUpper(strings.TrimSpace(value))
+
+	multiplier := int64(1)
+	if len(value) > 0 {
+		if unit, ok := units[value[len(value)-1:]]; ok {
+			multiplier = unit
+			value = value[:len(value)-1]
+		}
+	}
+
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if size < 0 {
+		return 0, fmt.Errorf("size should not be negative: %d", size)
+	}
+
+	return size * multiplier, nil
+}
+
+// checkPublication resolves the page and attachments of the file without
+// changing anything in Confluence, so warnings about missing parents and
+// attachments fail the file in strict mode before it's published.
+func checkPublication(
+	api confluence.Client,
+	file string,
+	meta *mark.Meta,
+	markdown []byte,
+	resolve bool,
+) error {
+	if resolve {
+		_, _, err := mark.ResolvePage(true, api, meta)
 		if err != nil {
-			log.Fatal(err)
+			return fail(ExitPublish, karma.Describe("title", meta.Title).Format(
+				err,
+				"unable to resolve page",
+			))
 		}
 	}
 
+	attaches, err := mark.ExpandAttachments(
+		filepath.Dir(file),
+		meta.Attachments,
+	)
+	if err != nil {
+		return fail(ExitSource, err)
+	}
+
+	mark.CompileAttachmentLinks(markdown, attaches)
+
+	return checkWarnings(true)
+}
+
+func processFile(
+	api confluence.Client,
+	creds *Credentials,
+	args map[string]interface{},
+	file string,
+	previous *Result,
+	result *Result,
+) error {
+	var (
+		compileOnly = args["--compile-only"].(bool)
+		dryRun      = args["--dry-run"].(bool)
+		editLock    = args["-k"].(bool)
+		writeBack   = args["--write-back"].(bool)
+
+		pruneAttachments = args["--prune-attachments"].(bool)
+		strict           = args["--strict"].(bool)
+		treeRoot, _      = args["--tree-root"].(string)
+	)
+
+	sizeLimit, err := parseSize(args["--max-attachment-size"].(string))
+	if err != nil {
+		return fail(
+			ExitConfig,
+			karma.Format(err, "invalid --max-attachment-size value"),
+		)
+	}
+
+	source, err := readSource(api, file)
+	if err != nil {
+		return err
+	}
+
+	err = setTreeParents(treeRoot, file, source.meta)
+	if err != nil {
+		return err
+	}
+
+	// warnings about the file itself are known at this point, so nothing is
+	// changed in Confluence when they are treated as errors
+	err = checkWarnings(strict)
+	if err != nil {
+		return err
+	}
+
+	result.files = source.files
+
+	var (
+		meta     = source.meta
+		markdown = source.markdown
+		stdlib   = source.stdlib
+	)
+
 	if dryRun {
 		compileOnly = true
 
-		_, _, err := mark.ResolvePage(dryRun, api, meta)
-		if err != nil {
-			log.Fatalf(err, "unable to resolve page location")
+		if meta != nil {
+			_, page, err := mark.ResolvePage(dryRun, api, meta)
+			if err != nil {
+				return fail(
+					ExitPublish,
+					karma.Format(err, "unable to resolve page location"),
+				)
+			}
+
+			result.Page = page
 		}
 	}
 
 	if compileOnly {
-		fmt.Println(mark.CompileMarkdown(markdown, stdlib))
-		os.Exit(0)
+		html := mark.CompileMarkdown(markdown, stdlib)
+
+		err := source.validate(file, html)
+		if err != nil {
+			log.Warningf(err, "page will be rejected by Confluence")
+		}
+
+		err = checkWarnings(strict)
+		if err != nil {
+			return err
+		}
+
+		result.HTML = html
+		result.Action = ActionSkipped
+
+		return nil
+	}
+
+	// attachment links don't affect validity of the page, so it's checked
+	// before any changes are made in Confluence
+	err = source.validate(file, mark.CompileMarkdown(markdown, stdlib))
+	if err != nil {
+		return fail(ExitSource, err)
 	}
 
 	if creds.PageID != "" && meta != nil {
@@ -229,52 +706,111 @@ func main() {
 	}
 
 	if creds.PageID == "" && meta == nil {
-		log.Fatal(
-			`specified file doesn't contain metadata ` +
-				`and URL is not specified via command line ` +
+		return fail(ExitSource, errors.New(
+			`specified file doesn't contain metadata `+
+				`and URL is not specified via command line `+
 				`or doesn't contain pageId GET-parameter`,
-		)
+		))
 	}
 
 	var target *confluence.PageInfo
 
+	created := false
+
 	if meta != nil {
+		result.location = getPageLocation(meta)
+	}
+
+	resolved := meta != nil && previous != nil && previous.Page != nil &&
+		previous.location == result.location
+
+	if strict && meta != nil {
+		err := checkPublication(api, file, meta, markdown, !resolved)
+		if err != nil {
+			return err
+		}
+	}
+
+	if resolved {
+		log.Debugf(nil, "using previously resolved page %q", meta.Title)
+
+		target = previous.Page
+	} else if meta != nil {
 		parent, page, err := mark.ResolvePage(dryRun, api, meta)
 		if err != nil {
-			log.Fatalf(
-				karma.Describe("title", meta.Title).Reason(err),
+			return fail(ExitPublish, karma.Describe("title", meta.Title).Format(
+				err,
 				"unable to resolve page",
-			)
+			))
 		}
 
 		if page == nil {
-			page, err = api.CreatePage(meta.Space, parent, meta.Title, ``)
+			if meta.Type == confluence.ContentTypeBlogPost {
+				page, err = api.CreateBlogPost(meta.Space, meta.Title, ``)
+			} else {
+				page, err = api.CreatePage(meta.Space, parent, meta.Title, ``)
+			}
 			if err != nil {
-				log.Fatalf(
+				return fail(ExitPublish, karma.Format(
 					err,
 					"can't create page %q",
 					meta.Title,
-				)
+				))
 			}
+
+			created = true
 		}
 
 		target = page
 	} else {
-		if creds.PageID == "" {
-			log.Fatalf(nil, "URL should provide 'pageId' GET-parameter")
-		}
-
 		page, err := api.GetPageByID(creds.PageID)
 		if err != nil {
-			log.Fatalf(err, "unable to retrieve page by id")
+			return fail(
+				ExitPublish,
+				karma.Format(err, "unable to retrieve page by id"),
+			)
 		}
 
 		target = page
+
+		meta = &mark.Meta{}
 	}
 
-	attaches, err := mark.ResolveAttachments(api, target, ".", meta.Attachments)
+	result.Page = target
+
+	attaches, err := mark.ResolveAttachments(
+		api,
+		target,
+		filepath.Dir(file),
+		meta.Attachments,
+		meta.AttachmentComment,
+		sizeLimit,
+	)
 	if err != nil {
-		log.Fatalf(err, "unable to create/update attachments")
+		return fail(
+			ExitPublish,
+			karma.Format(err, "unable to create/update attachments"),
+		)
+	}
+
+	for _, attach := range attaches {
+		if attach.Changed {
+			result.Attachments = append(result.Attachments, attach.Filename)
+		}
+
+		result.files = append(result.files, attach.Path)
+	}
+
+	if pruneAttachments {
+		removed, err := mark.PruneAttachments(api, target, attaches)
+		if err != nil {
+			return fail(
+				ExitPublish,
+				karma.Format(err, "unable to remove stale attachments"),
+			)
+		}
+
+		result.Attachments = append(result.Attachments, removed...)
 	}
 
 	markdown = mark.CompileAttachmentLinks(markdown, attaches)
@@ -296,15 +832,71 @@ func main() {
 			},
 		)
 		if err != nil {
-			log.Fatal(err)
+			return fail(ExitSource, err)
 		}
 
 		html = buffer.String()
 	}
 
-	err = api.UpdatePage(target, html)
+	err = source.validate(file, html)
 	if err != nil {
-		log.Fatal(err)
+		return fail(ExitSource, err)
+	}
+
+	err = checkWarnings(strict)
+	if err != nil {
+		return err
+	}
+
+	changed, err := mark.IsContentChanged(api, target, html)
+	if err != nil {
+		return fail(ExitPublish, err)
+	}
+
+	switch {
+	case created:
+		result.Action = ActionCreated
+	case changed:
+		result.Action = ActionUpdated
+	default:
+		result.Action = ActionUnchanged
+	}
+
+	if changed {
+		err = api.UpdatePage(target, html)
+		if err != nil {
+			return fail(ExitPublish, err)
+		}
+
+		err = mark.SetContentChecksum(api, target, html)
+		if err != nil {
+			return fail(ExitPublish, err)
+		}
+	} else {
+		log.Infof(nil, "page content is not changed: %q", target.Title)
+	}
+
+	if meta.Source != "" {
+		err := mark.SetPageSource(api, target, meta.Source)
+		if err != nil {
+			return fail(ExitPublish, err)
+		}
+	}
+
+	err = mark.SetPageProperties(api, target, meta)
+	if err != nil {
+		return fail(ExitPublish, err)
+	}
+
+	if writeBack && meta.ID == "" && creds.PageID == "" {
+		err := writeBackID(file, target.ID)
+		if err != nil {
+			return fail(ExitSource, karma.Format(
+				err,
+				"unable to write page id back to %q",
+				file,
+			))
+		}
 	}
 
 	if editLock {
@@ -320,17 +912,28 @@ func main() {
 			creds.Username,
 		)
 		if err != nil {
-			log.Fatal(err)
+			return fail(ExitPublish, err)
 		}
 	}
 
 	log.Infof(
 		nil,
-		"page successfully updated: %s",
+		"page %s: %s",
+		result.Action,
 		creds.BaseURL+target.Links.Full,
 	)
 
-	fmt.Println(
-		creds.BaseURL + target.Links.Full,
+	return nil
+}
+
+// getPageLocation returns headers which define location of the page, so page
+// resolved once can be reused while they are not changed.
+func getPageLocation(meta *mark.Meta) string {
+	return strings.Join(
+		append(
+			[]string{meta.ID, meta.Space, meta.Type, meta.Date, meta.Title},
+			meta.Parents...,
+		),
+		"\n",
 	)
 }
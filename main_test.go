@@ -0,0 +1,1182 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kovetskiy/mark/pkg/confluence"
+	"github.com/kovetskiy/mark/pkg/confluence/fake"
+	"github.com/kovetskiy/mark/pkg/log"
+	"github.com/kovetskiy/mark/pkg/mark"
+)
+
+func TestMain(m *testing.M) {
+	log.Init(false, false)
+
+	os.Exit(m.Run())
+}
+
+// setupWorkdir changes current directory to a new temporary directory for
+// the duration of the test and returns fake Confluence with space DOC.
+func setupWorkdir(t *testing.T) *fake.Confluence {
+	dir, err := ioutil.TempDir("", "mark-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		os.Chdir(cwd)
+		os.RemoveAll(dir)
+	})
+
+	api := fake.New()
+	api.AddSpace("DOC", "Home")
+
+	return api
+}
+
+func writeFile(t *testing.T, path string, contents string) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// publish processes file the same way as main does with default options,
+// given flags are enabled or set if they have value, like --tree-root=docs.
+func publish(
+	api confluence.Client,
+	file string,
+	flags ...string,
+) (*Result, error) {
+	args := map[string]interface{}{
+		"--compile-only":        false,
+		"--dry-run":             false,
+		"-k":                    false,
+		"--write-back":          false,
+		"--prune-attachments":   false,
+		"--strict":              false,
+		"--max-attachment-size": "0",
+	}
+
+	for _, flag := range flags {
+		if parts := strings.SplitN(flag, "=", 2); len(parts) == 2 {
+			args[parts[0]] = parts[1]
+
+			continue
+		}
+
+		args[flag] = true
+	}
+
+	result := newResult(file)
+
+	err := processFile(
+		api,
+		&Credentials{BaseURL: "http://confluence.local"},
+		args,
+		file,
+		nil,
+		result,
+	)
+
+	result.finish(OutputText, "http://confluence.local", err)
+
+	return result, err
+}
+
+func mustPublish(
+	t *testing.T,
+	api confluence.Client,
+	file string,
+	flags ...string,
+) *Result {
+	t.Helper()
+
+	result, err := publish(api, file, flags...)
+	if err != nil {
+		t.Fatalf("unable to publish %q: %s", file, err)
+	}
+
+	return result
+}
+
+func findPage(t *testing.T, api *fake.Confluence, title string) *fake.Page {
+	t.Helper()
+
+	for _, page := range api.Pages() {
+		if page.Title == title && page.Status == "current" {
+			return page
+		}
+	}
+
+	t.Fatalf("page %q is not found", title)
+
+	return nil
+}
+
+func assertAction(t *testing.T, expected string, result *Result) {
+	t.Helper()
+
+	if result.Action != expected {
+		t.Fatalf(
+			"expected page to be %s, but it was %s",
+			expected,
+			result.Action,
+		)
+	}
+}
+
+func TestPublish_CreateUpdateUnchanged(t *testing.T) {
+	api := setupWorkdir(t)
+
+	writeFile(t, "page.md", "<!-- Space: DOC -->\n<!-- Title: Page -->\n\nhello\n")
+
+	result := mustPublish(t, api, "page.md")
+	assertAction(t, ActionCreated, result)
+
+	page := findPage(t, api, "Page")
+	if page.ParentID != findPage(t, api, "Home").ID {
+		t.Fatalf("page should be created under space home page")
+	}
+
+	if !strings.Contains(page.Body, "<p>hello</p>") {
+		t.Fatalf("unexpected page body: %s", page.Body)
+	}
+
+	version := page.Version
+
+	// content checksum is stored, so the same content is not published
+	result = mustPublish(t, api, "page.md")
+	assertAction(t, ActionUnchanged, result)
+
+	if findPage(t, api, "Page").Version != version {
+		t.Fatalf("unchanged page should not get new version")
+	}
+
+	writeFile(t, "page.md", "<!-- Space: DOC -->\n<!-- Title: Page -->\n\nbye\n")
+
+	result = mustPublish(t, api, "page.md")
+	assertAction(t, ActionUpdated, result)
+
+	page = findPage(t, api, "Page")
+	if page.Version != version+1 {
+		t.Fatalf("expected version %d, got %d", version+1, page.Version)
+	}
+
+	if !strings.Contains(page.Body, "<p>bye</p>") {
+		t.Fatalf("unexpected page body: %s", page.Body)
+	}
+
+	if len(api.Pages()) != 2 {
+		t.Fatalf("expected 2 pages, got %d", len(api.Pages()))
+	}
+}
+
+func TestPublish_ManualEditIsOverwritten(t *testing.T) {
+	api := setupWorkdir(t)
+
+	writeFile(t, "page.md", "<!-- Space: DOC -->\n<!-- Title: Page -->\n\nhello\n")
+
+	mustPublish(t, api, "page.md")
+
+	info, err := api.FindPage("DOC", "Page")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = api.UpdatePage(info, "<p>edited in Confluence</p>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := mustPublish(t, api, "page.md")
+	assertAction(t, ActionUpdated, result)
+
+	if !strings.Contains(findPage(t, api, "Page").Body, "<p>hello</p>") {
+		t.Fatalf("manual edit should be overwritten by file contents")
+	}
+}
+
+func TestPublish_RenameAndMove(t *testing.T) {
+	api := setupWorkdir(t)
+
+	writeFile(t, "page.md", "<!-- Space: DOC -->\n<!-- Title: Old -->\n\ntext\n")
+
+	mustPublish(t, api, "page.md")
+
+	id := findPage(t, api, "Old").ID
+
+	writeFile(t, "page.md", "<!-- Space: DOC -->\n<!-- Title: New -->\n\ntext\n")
+
+	result := mustPublish(t, api, "page.md")
+	assertAction(t, ActionUpdated, result)
+
+	if findPage(t, api, "New").ID != id {
+		t.Fatalf("page should be renamed instead of created")
+	}
+
+	writeFile(
+		t,
+		"page.md",
+		"<!-- Space: DOC -->\n<!-- Parent: Section -->\n<!-- Title: New -->\n\ntext\n",
+	)
+
+	mustPublish(t, api, "page.md")
+
+	page := findPage(t, api, "New")
+	if page.ID != id {
+		t.Fatalf("page should be moved instead of created")
+	}
+
+	if page.ParentID != findPage(t, api, "Section").ID {
+		t.Fatalf("page should be moved under created parent")
+	}
+
+	if len(api.Pages()) != 3 {
+		t.Fatalf("expected 3 pages, got %d", len(api.Pages()))
+	}
+}
+
+func TestPublish_Attachments(t *testing.T) {
+	api := setupWorkdir(t)
+
+	writeFile(t, "docs/img.png", "one")
+	writeFile(t, "docs/notes.txt", "notes")
+	writeFile(
+		t,
+		"docs/page.md",
+		"<!-- Space: DOC -->\n<!-- Title: Page -->\n"+
+			"<!-- Attachment: img.png -->\n<!-- Attachment: notes.txt -->\n\n"+
+			"![](img.png)\n\n[notes](notes.txt)\n",
+	)
+
+	result := mustPublish(t, api, "docs/page.md")
+
+	if len(result.Attachments) != 2 {
+		t.Fatalf("expected 2 uploaded attachments, got %v", result.Attachments)
+	}
+
+	page := findPage(t, api, "Page")
+	if len(page.Attachments) != 2 {
+		t.Fatalf("expected 2 attachments, got %d", len(page.Attachments))
+	}
+
+	if !strings.Contains(page.Body, "/download/attachments/"+page.ID+"/img.png") {
+		t.Fatalf("image link is not replaced: %s", page.Body)
+	}
+
+	// unchanged attachments are not uploaded again
+	result = mustPublish(t, api, "docs/page.md")
+	if len(result.Attachments) != 0 {
+		t.Fatalf("expected no uploaded attachments, got %v", result.Attachments)
+	}
+
+	writeFile(t, "docs/img.png", "two")
+
+	result = mustPublish(t, api, "docs/page.md")
+	if len(result.Attachments) != 1 || result.Attachments[0] != "img.png" {
+		t.Fatalf("expected img.png to be updated, got %v", result.Attachments)
+	}
+
+	for _, attachment := range findPage(t, api, "Page").Attachments {
+		if attachment.Filename == "img.png" &&
+			(attachment.Version != 2 || string(attachment.Data) != "two") {
+			t.Fatalf("attachment is not updated: %#v", attachment)
+		}
+	}
+
+	writeFile(
+		t,
+		"docs/page.md",
+		"<!-- Space: DOC -->\n<!-- Title: Page -->\n"+
+			"<!-- Attachment: img.png -->\n\n![](img.png)\n",
+	)
+
+	mustPublish(t, api, "docs/page.md", "--prune-attachments")
+
+	page = findPage(t, api, "Page")
+	if len(page.Attachments) != 1 || page.Attachments[0].Filename != "img.png" {
+		t.Fatalf("stale attachment is not removed: %#v", page.Attachments)
+	}
+}
+
+func TestPublish_Prune(t *testing.T) {
+	api := setupWorkdir(t)
+
+	home, err := api.FindRootPage("DOC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := api.CreatePage("DOC", home, "Docs", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = api.AddPageLabels(root.ID, []string{mark.LabelManagedRoot})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(
+		t, "a.md",
+		"<!-- Space: DOC -->\n<!-- Parent: Docs -->\n<!-- Title: A -->\n\na\n",
+	)
+	writeFile(
+		t, "b.md",
+		"<!-- Space: DOC -->\n<!-- Parent: Docs -->\n<!-- Title: B -->\n\nb\n",
+	)
+
+	mustPublish(t, api, "a.md")
+	mustPublish(t, api, "b.md")
+
+	// page which is not managed by mark is outside of managed root
+	_, err = api.CreatePage("DOC", home, "Manual", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Remove("b.md")
+
+	result := mustPublish(t, api, "a.md")
+
+	stale, err := mark.FindStalePages(api, []*confluence.PageInfo{result.Page})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stale) != 1 || stale[0].Title != "B" {
+		t.Fatalf("expected B to be stale, got %#v", stale)
+	}
+
+	err = mark.PrunePages(api, stale, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if page := api.Page(stale[0].ID); page.Status != "trashed" {
+		t.Fatalf("stale page is not removed: %#v", page)
+	}
+
+	findPage(t, api, "A")
+	findPage(t, api, "Manual")
+}
+
+func TestPublish_PruneSubset(t *testing.T) {
+	api := setupWorkdir(t)
+
+	home, err := api.FindRootPage("DOC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := api.CreatePage("DOC", home, "Docs", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = api.AddPageLabels(root.ID, []string{mark.LabelManagedRoot})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pages := []struct {
+		file    string
+		section string
+		title   string
+	}{
+		{"ops/a.md", "Ops", "A"},
+		{"ops/b.md", "Ops", "B"},
+		{"dev/c.md", "Dev", "C"},
+	}
+
+	for _, page := range pages {
+		writeFile(
+			t, page.file,
+			"<!-- Space: DOC -->\n<!-- Parent: Docs -->\n"+
+				"<!-- Parent: "+page.section+" -->\n"+
+				"<!-- Title: "+page.title+" -->\n\n"+page.title+"\n",
+		)
+
+		mustPublish(t, api, page.file)
+	}
+
+	os.Remove("ops/b.md")
+
+	// only ops directory is published, so pages of dev directory and its
+	// parent page are not stale
+	result := mustPublish(t, api, "ops/a.md")
+
+	stale, err := mark.FindStalePages(api, []*confluence.PageInfo{result.Page})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stale) != 1 || stale[0].Title != "B" {
+		t.Fatalf("expected only B to be stale, got %#v", stale)
+	}
+}
+
+func git(t *testing.T, args ...string) {
+	t.Helper()
+
+	cmd := exec.Command(
+		"git",
+		append([]string{"-c", "user.name=mark", "-c", "user.email=mark@localhost"}, args...)...,
+	)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %s\n%s", strings.Join(args, " "), err, output)
+	}
+}
+
+func TestPublish_SourceFromSubdirectory(t *testing.T) {
+	api := setupWorkdir(t)
+
+	git(t, "init", "-q")
+
+	writeFile(t, "docs/page.md", "<!-- Space: DOC -->\n<!-- Title: Old -->\n\ntext\n")
+
+	mustPublish(t, api, "docs/page.md")
+
+	id := findPage(t, api, "Old").ID
+
+	err := os.Chdir("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// source path is relative to repository root, so the page is still
+	// recognized when mark is run from other directory
+	writeFile(t, "page.md", "<!-- Space: DOC -->\n<!-- Title: New -->\n\ntext\n")
+
+	result := mustPublish(t, api, "page.md")
+	assertAction(t, ActionUpdated, result)
+
+	if findPage(t, api, "New").ID != id {
+		t.Fatalf("page should be renamed instead of created")
+	}
+}
+
+func TestPublish_SourceMovedInGit(t *testing.T) {
+	api := setupWorkdir(t)
+
+	git(t, "init", "-q")
+
+	writeFile(t, "old.md", "<!-- Space: DOC -->\n<!-- Title: Old -->\n\ntext\n")
+
+	git(t, "add", "old.md")
+	git(t, "commit", "-q", "-m", "add old")
+
+	mustPublish(t, api, "old.md")
+
+	page := findPage(t, api, "Old")
+	if !hasLabel(page, mark.LabelSourcePrefix) {
+		t.Fatalf("source label is not set: %v", page.Labels)
+	}
+
+	previous := page.Labels
+
+	git(t, "mv", "old.md", "new.md")
+	git(t, "commit", "-q", "-m", "move old")
+
+	writeFile(t, "new.md", "<!-- Space: DOC -->\n<!-- Title: New -->\n\ntext\n")
+
+	result := mustPublish(t, api, "new.md")
+	assertAction(t, ActionUpdated, result)
+
+	page = findPage(t, api, "New")
+	if page.ID != result.Page.ID || len(api.Pages()) != 2 {
+		t.Fatalf("moved file should update the same page")
+	}
+
+	sources := 0
+	for _, label := range page.Labels {
+		if strings.HasPrefix(label, mark.LabelSourcePrefix) {
+			sources++
+		}
+
+		for _, old := range previous {
+			if strings.HasPrefix(old, mark.LabelSourcePrefix) && old == label {
+				t.Fatalf("stale source label %q is not removed", label)
+			}
+		}
+	}
+
+	if sources != 1 {
+		t.Fatalf("expected single source label, got %v", page.Labels)
+	}
+}
+
+func hasLabel(page *fake.Page, prefix string) bool {
+	for _, label := range page.Labels {
+		if strings.HasPrefix(label, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestPublish_BlogPostDate(t *testing.T) {
+	api := setupWorkdir(t)
+
+	today := time.Now().Format("2006-01-02")
+
+	writeFile(
+		t, "post.md",
+		"<!-- Space: DOC -->\n<!-- Type: blogpost -->\n"+
+			"<!-- Date: "+today+" -->\n<!-- Title: Post -->\n\ntext\n",
+	)
+
+	result := mustPublish(t, api, "post.md")
+	assertAction(t, ActionCreated, result)
+
+	if findPage(t, api, "Post").Created != today {
+		t.Fatalf("blog post should be posted today")
+	}
+
+	// posting day can't be set through API, so blog post which is not
+	// found by date is not created
+	writeFile(
+		t, "old.md",
+		"<!-- Space: DOC -->\n<!-- Type: blogpost -->\n"+
+			"<!-- Date: 2020-01-02 -->\n<!-- Title: Old -->\n\ntext\n",
+	)
+
+	_, err := publish(api, "old.md")
+	if err == nil || !strings.Contains(err.Error(), "2020-01-02") {
+		t.Fatalf("expected error about posting day, got %v", err)
+	}
+
+	if len(api.Pages()) != 2 {
+		t.Fatalf("blog post with past date should not be created")
+	}
+}
+
+func TestPublish_PageProperties(t *testing.T) {
+	api := setupWorkdir(t)
+
+	property := func(key string) interface{} {
+		t.Helper()
+
+		page := findPage(t, api, "Page")
+
+		value, err := api.GetContentProperty(page.ID, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if value == nil {
+			return nil
+		}
+
+		return value.Value
+	}
+
+	writeFile(
+		t, "page.md",
+		"<!-- Space: DOC -->\n<!-- Title: Page -->\n"+
+			"<!-- Appearance: fixed -->\n<!-- Emoji: 🚀 -->\n\ntext\n",
+	)
+
+	mustPublish(t, api, "page.md")
+
+	if value := property("content-appearance-published"); value != "fixed-width" {
+		t.Fatalf("unexpected appearance: %v", value)
+	}
+
+	if value := property("emoji-title-draft"); value != "1f680" {
+		t.Fatalf("unexpected emoji: %v", value)
+	}
+
+	writeFile(
+		t, "page.md",
+		"<!-- Space: DOC -->\n<!-- Title: Page -->\n"+
+			"<!-- Appearance: full-width -->\n\ntext\n",
+	)
+
+	mustPublish(t, api, "page.md")
+
+	if value := property("content-appearance-draft"); value != "full-width" {
+		t.Fatalf("unexpected appearance: %v", value)
+	}
+
+	for _, key := range []string{"emoji-title-published", "emoji-title-draft"} {
+		if value := property(key); value != nil {
+			t.Fatalf("property %q should be removed, got %v", key, value)
+		}
+	}
+
+	writeFile(t, "page.md", "<!-- Space: DOC -->\n<!-- Title: Page -->\n\ntext\n")
+
+	mustPublish(t, api, "page.md")
+
+	for _, key := range []string{
+		"content-appearance-published",
+		"content-appearance-draft",
+		mark.PropertyProperties,
+	} {
+		if value := property(key); value != nil {
+			t.Fatalf("property %q should be removed, got %v", key, value)
+		}
+	}
+}
+
+func TestPublish_AttachmentRelativePath(t *testing.T) {
+	api := setupWorkdir(t)
+
+	writeFile(t, "img.png", "one")
+	writeFile(
+		t, "page.md",
+		"<!-- Space: DOC -->\n<!-- Title: Page -->\n"+
+			"<!-- Attachment: ./img.png -->\n\n"+
+			"![](./img.png)\n\nimg.png is not a link\n",
+	)
+
+	mustPublish(t, api, "page.md")
+
+	page := findPage(t, api, "Page")
+	if len(page.Attachments) != 1 || page.Attachments[0].Filename != "img.png" {
+		t.Fatalf("unexpected attachments: %#v", page.Attachments)
+	}
+
+	if strings.Contains(page.Body, ".//download") ||
+		!strings.Contains(page.Body, `"/download/attachments/`+page.ID+"/img.png") {
+		t.Fatalf("image link is not replaced: %s", page.Body)
+	}
+
+	if !strings.Contains(page.Body, "img.png is not a link") {
+		t.Fatalf("text should not be replaced: %s", page.Body)
+	}
+}
+
+func TestPublish_AttachmentLegacyName(t *testing.T) {
+	api := setupWorkdir(t)
+
+	writeFile(t, "img.png", "one")
+	writeFile(
+		t, "page.md",
+		"<!-- Space: DOC -->\n<!-- Title: Page -->\n"+
+			"<!-- Attachment: ./img.png -->\n\n![](./img.png)\n",
+	)
+
+	home, err := api.FindRootPage("DOC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := api.CreatePage("DOC", home, "Page", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// previous versions of mark named attachment after path as written
+	_, err = api.CreateAttachment(page.ID, "._img.png", "", "img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mustPublish(t, api, "page.md")
+
+	attachments := findPage(t, api, "Page").Attachments
+	if len(attachments) != 1 || attachments[0].Filename != "._img.png" {
+		t.Fatalf("attachment should keep its name: %#v", attachments)
+	}
+}
+
+func TestPublish_AttachmentAliasSeparator(t *testing.T) {
+	api := setupWorkdir(t)
+
+	writeFile(t, "notes as of may.pdf", "notes")
+	writeFile(t, "plan as draft.pdf", "plan")
+
+	writeFile(
+		t, "page.md",
+		"<!-- Space: DOC -->\n<!-- Title: Page -->\n"+
+			"<!-- Attachment: notes as of may.pdf -->\n"+
+			"<!-- Attachment: plan as draft.pdf as plan as of june.pdf -->\n\n"+
+			"[notes](notes as of may.pdf)\n[plan](plan as draft.pdf)\n",
+	)
+
+	mustPublish(t, api, "page.md")
+
+	names := []string{}
+	for _, attachment := range findPage(t, api, "Page").Attachments {
+		names = append(names, attachment.Filename)
+	}
+
+	sort.Strings(names)
+
+	if strings.Join(names, ", ") != "notes as of may.pdf, plan as of june.pdf" {
+		t.Fatalf("unexpected attachment names: %v", names)
+	}
+}
+
+func TestImport_RoundTrip(t *testing.T) {
+	remote := setupWorkdir(t)
+
+	writeFile(t, "pic one.png", "picture")
+	writeFile(t, "notes as of may.pdf", "notes")
+
+	home, err := remote.FindRootPage("DOC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = remote.UpdatePage(
+		home,
+		`<p>Welcome</p><p><ac:image><ri:attachment ri:filename="pic one.png"/>`+
+			`</ac:image></p>`,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"pic one.png", "notes as of may.pdf"} {
+		_, err = remote.CreateAttachment(home.ID, name, "", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = remote.CreatePage("DOC", home, "Guide", "<p>guide</p>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = importPages(remote, "DOC", "", "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api := fake.New()
+	api.AddSpace("DOC", "Home")
+
+	for _, file := range []string{"out/index.md", "out/guide.md"} {
+		mustPublish(t, api, file)
+	}
+
+	page := findPage(t, api, "Home")
+	if !strings.Contains(page.Body, "<p>Welcome</p>") {
+		t.Fatalf("home page is not updated: %s", page.Body)
+	}
+
+	names := []string{}
+	for _, attachment := range page.Attachments {
+		names = append(names, attachment.Filename)
+	}
+
+	sort.Strings(names)
+
+	if strings.Join(names, ", ") != "notes as of may.pdf, pic one.png" {
+		t.Fatalf("attachments should keep their names: %v", names)
+	}
+
+	if !strings.Contains(page.Body, "/download/attachments/"+page.ID+"/") {
+		t.Fatalf("attachment link is not replaced: %s", page.Body)
+	}
+
+	if findPage(t, api, "Guide").ParentID != page.ID {
+		t.Fatalf("child page should be published under home page")
+	}
+
+	if len(api.Pages()) != 2 {
+		t.Fatalf("expected 2 pages, got %d", len(api.Pages()))
+	}
+}
+
+// captureStdout returns everything written to stdout by given function.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = writer
+
+	defer func() {
+		os.Stdout = stdout
+	}()
+
+	fn()
+
+	writer.Close()
+
+	output, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(output)
+}
+
+func TestOutput_CompileOnlyJSON(t *testing.T) {
+	api := setupWorkdir(t)
+
+	writeFile(t, "page.md", "<!-- Space: DOC -->\n<!-- Title: Page -->\n\nhello\n")
+
+	output := captureStdout(t, func() {
+		result := newResult("page.md")
+
+		err := processFile(
+			api,
+			&Credentials{BaseURL: "http://confluence.local"},
+			map[string]interface{}{
+				"--compile-only":        true,
+				"--dry-run":             false,
+				"-k":                    false,
+				"--write-back":          false,
+				"--prune-attachments":   false,
+				"--strict":              false,
+				"--max-attachment-size": "0",
+			},
+			"page.md",
+			nil,
+			result,
+		)
+
+		result.finish(OutputJSON, "http://confluence.local", err)
+	})
+
+	// compiled page is a part of the record, so output stays parseable
+	var record map[string]interface{}
+
+	err := json.Unmarshal([]byte(output), &record)
+	if err != nil {
+		t.Fatalf("output is not a JSON record: %s\n%s", err, output)
+	}
+
+	if record["action"] != ActionSkipped {
+		t.Fatalf("unexpected action: %v", record["action"])
+	}
+
+	html, _ := record["html"].(string)
+	if !strings.Contains(html, "<p>hello</p>") {
+		t.Fatalf("compiled page is not in the record: %v", record)
+	}
+}
+
+func TestStrict_IgnoresErrors(t *testing.T) {
+	log.TakeWarnings()
+
+	// errors are reported by their callers and don't belong to the file
+	log.Errorf(nil, "unable to write report")
+
+	err := checkWarnings(true)
+	if err != nil {
+		t.Fatalf("logged error should not fail strict mode: %s", err)
+	}
+
+	log.Warningf(nil, "unused attachment")
+
+	err = checkWarnings(true)
+	if err == nil || !strings.Contains(err.Error(), "unused attachment") {
+		t.Fatalf("expected warning to fail strict mode, got %v", err)
+	}
+
+	log.TakeWarnings()
+}
+
+func TestLint_AllProblems(t *testing.T) {
+	setupWorkdir(t)
+
+	writeFile(
+		t, "page.md",
+		"<!-- Space: DOC -->\n<!-- Type: note -->\n<!-- Unknown: value -->\n\n"+
+			"<!-- Include: missing-one.md -->\n\n"+
+			"<!-- Include: missing-two.md -->\n",
+	)
+
+	diagnostics := lintFile("page.md", "")
+
+	lines := []string{}
+	for _, diagnostic := range diagnostics {
+		lines = append(lines, diagnostic.String())
+	}
+
+	expected := []string{
+		"page.md:1: page title is not set",
+		"page.md:2: unsupported content type",
+		"page.md:5: unable to load template",
+		"page.md:7: unable to load template",
+		"page.md:3: encountered unknown header",
+	}
+
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d problems, got:\n%s", len(expected), strings.Join(lines, "\n"))
+	}
+
+	for i, prefix := range expected {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Fatalf("expected problem %q, got %q", prefix, lines[i])
+		}
+	}
+
+	// the page is specified with -l when such file is published
+	writeFile(t, "plain.md", "text\n")
+
+	diagnostics = lintFile("plain.md", "")
+	if len(diagnostics) != 0 {
+		t.Fatalf("file without headers should pass, got %v", diagnostics)
+	}
+}
+
+func TestPublish_MissingHeaders(t *testing.T) {
+	api := setupWorkdir(t)
+
+	writeFile(t, "page.md", "<!-- Title: Page -->\n\ntext\n")
+
+	_, err := publish(api, "page.md")
+	if err == nil || !strings.HasPrefix(err.Error(), "page.md:1: space key is not set") {
+		t.Fatalf("expected missing space at line 1, got %v", err)
+	}
+}
+
+func TestLint_TreeRoot(t *testing.T) {
+	setupWorkdir(t)
+
+	writeFile(t, "docs/ops/page.md", "<!-- Space: DOC -->\n<!-- Title: Page -->\n\ntext\n")
+	writeFile(t, "other/page.md", "<!-- Space: DOC -->\n<!-- Title: Other -->\n\ntext\n")
+
+	diagnostics := lintFile("docs/ops/page.md", "docs")
+	if len(diagnostics) != 0 {
+		t.Fatalf("unexpected problems: %v", diagnostics)
+	}
+
+	// the same check as publishing does with --tree-root
+	diagnostics = lintFile("other/page.md", "docs")
+	if len(diagnostics) != 1 ||
+		!strings.Contains(diagnostics[0].Message, "outside of tree root") {
+		t.Fatalf("expected file outside of tree root, got %v", diagnostics)
+	}
+}
+
+func TestLint_TreeIndexError(t *testing.T) {
+	setupWorkdir(t)
+
+	writeFile(t, "docs/ops/index.md", "<!-- Space: DOC -->\n\nops\n")
+	writeFile(t, "docs/ops/db.md", "<!-- Space: DOC -->\n<!-- Title: DB -->\n\ndb\n")
+
+	// problem of index file is not blamed on the file in its directory
+	diagnostics := lintFile("docs/ops/db.md", "docs")
+	if len(diagnostics) != 1 ||
+		!strings.Contains(diagnostics[0].Message, `"docs/ops/index.md"`) {
+		t.Fatalf("expected invalid index file, got %v", diagnostics)
+	}
+}
+
+func TestLint_StorageErrorLine(t *testing.T) {
+	api := setupWorkdir(t)
+
+	writeFile(
+		t, "page.md",
+		"<!-- Space: DOC -->\n<!-- Title: Page -->\n\n"+
+			"<div>\n<p>text</p>\n<b>\n<p>more</p>\n</div>\n",
+	)
+
+	// unclosed element is reported, not closing tag of its parent
+	diagnostics := lintFile("page.md", "")
+	if len(diagnostics) != 1 || diagnostics[0].Line != 6 {
+		t.Fatalf("expected problem at line 6, got %v", diagnostics)
+	}
+
+	_, err := publish(api, "page.md")
+	if err == nil || !strings.HasPrefix(err.Error(), "page.md:6: ") {
+		t.Fatalf("expected storage error at line 6, got %v", err)
+	}
+}
+
+func TestPublish_TreeIndexWarnings(t *testing.T) {
+	api := setupWorkdir(t)
+
+	writeFile(
+		t, "docs/ops/index.md",
+		"<!-- Space: DOC -->\n<!-- Title: Operations -->\n"+
+			"<!-- Unknown: value -->\n\nops\n",
+	)
+
+	for _, name := range []string{"a", "b"} {
+		writeFile(
+			t, "docs/ops/"+name+".md",
+			"<!-- Space: DOC -->\n<!-- Title: "+name+" -->\n\n"+name+"\n",
+		)
+	}
+
+	// warnings of index file belong to the index file only
+	_, err := publish(api, "docs/ops/index.md", "--strict", "--tree-root=docs")
+	if err == nil || !strings.Contains(err.Error(), "unknown header") {
+		t.Fatalf("expected unknown header to fail index file, got %v", err)
+	}
+
+	result := mustPublish(t, api, "docs/ops/index.md", "--tree-root=docs")
+	if len(result.Warnings) != 1 {
+		t.Fatalf("expected single warning of index file, got %v", result.Warnings)
+	}
+
+	for _, name := range []string{"a", "b"} {
+		result := mustPublish(
+			t, api, "docs/ops/"+name+".md", "--strict", "--tree-root=docs",
+		)
+
+		if len(result.Warnings) != 0 {
+			t.Fatalf("unexpected warnings of %q: %v", name, result.Warnings)
+		}
+
+		if findPage(t, api, name).ParentID != findPage(t, api, "Operations").ID {
+			t.Fatalf("page %q should be published under its directory", name)
+		}
+	}
+}
+
+func TestExport_PDF(t *testing.T) {
+	setupWorkdir(t)
+
+	writeFile(
+		t, "docs/index.md",
+		"<!-- Space: DOC -->\n<!-- Title: Guide -->\n"+
+			"<!-- Attachment: image.png -->\n\n![](image.png)\n",
+	)
+	writeFile(t, "docs/image.png", "png")
+	writeFile(
+		t, "docs/setup.md",
+		"<!-- Space: DOC -->\n<!-- Parent: Guide -->\n"+
+			"<!-- Title: Setup -->\n\nsetup\n",
+	)
+
+	// converter keeps the document and directory it is written into, so
+	// links to attachments can be checked
+	writeFile(
+		t, "bin/wkhtmltopdf",
+		"#!/bin/sh\ncp \"$3\" \"$4\" && cp -r \"$(dirname \"$3\")\" \"$4.dir\"\n",
+	)
+
+	err := os.Chmod("bin/wkhtmltopdf", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bin, err := filepath.Abs("bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := os.Getenv("PATH")
+	t.Cleanup(func() {
+		os.Setenv("PATH", path)
+	})
+
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+path)
+
+	err = exportPages("docs", ExportPDF, "site", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	document, err := ioutil.ReadFile("site/doc.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guide := strings.Index(string(document), "<h1>Guide</h1>")
+	setup := strings.Index(string(document), "<h1>Setup</h1>")
+	if guide < 0 || setup < guide {
+		t.Fatalf("expected pages in tree order, got:\n%s", document)
+	}
+
+	image := "doc/guide/attachments/guide/image.png"
+	if !strings.Contains(string(document), `src="`+image+`"`) {
+		t.Fatalf("expected image %q in document, got:\n%s", image, document)
+	}
+
+	_, err = os.Stat(filepath.Join("site/doc.pdf.dir", image))
+	if err != nil {
+		t.Fatalf("image is not written next to document: %s", err)
+	}
+
+	files, err := ioutil.ReadDir("site")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pdf and copy of temporary directory made by converter
+	if len(files) != 2 {
+		t.Fatalf("expected only pdf in output directory, got %d files", len(files))
+	}
+
+	// no converter
+	os.Setenv("PATH", "")
+
+	err = exportPages("docs", ExportPDF, "site", "")
+	if err == nil || !strings.Contains(err.Error(), "wkhtmltopdf") {
+		t.Fatalf("expected missing converter error, got %v", err)
+	}
+}
+
+func TestStrict_NoChangesOnWarnings(t *testing.T) {
+	api := setupWorkdir(t)
+
+	writeFile(t, "image.png", "png")
+
+	tests := map[string]string{
+		"unknown header": "<!-- Space: DOC -->\n<!-- Parent: Docs -->\n" +
+			"<!-- Title: Page -->\n<!-- Unknown: value -->\n" +
+			"<!-- Attachment: image.png -->\n\n![](image.png)\n",
+		"missing parent": "<!-- Space: DOC -->\n<!-- Parent: Docs -->\n" +
+			"<!-- Title: Page -->\n" +
+			"<!-- Attachment: image.png -->\n\n![](image.png)\n",
+		"unused attachment": "<!-- Space: DOC -->\n<!-- Title: Page -->\n" +
+			"<!-- Attachment: image.png -->\n\ntext\n",
+	}
+
+	for name, contents := range tests {
+		writeFile(t, "page.md", contents)
+
+		_, err := publish(api, "page.md", "--strict")
+		if err == nil || !strings.Contains(err.Error(), "strict mode") {
+			t.Fatalf("%s: expected strict mode error, got %v", name, err)
+		}
+
+		// only home page of the space exists
+		pages := api.Pages()
+		if len(pages) != 1 {
+			t.Fatalf("%s: expected no pages to be created, got %d", name, len(pages))
+		}
+
+		attachments, err := api.GetAttachments(pages[0].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(attachments) != 0 {
+			t.Fatalf("%s: expected no attachments, got %v", name, attachments)
+		}
+	}
+}
@@ -1,20 +1,58 @@
 package confluence
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"os"
+	"reflect"
 	"strings"
 
 	"github.com/bndr/gopencils"
 	"github.com/reconquest/karma-go"
 )
 
+// resultsV1 holds pagination fields of REST API v1 response with list of
+// results. Server may return fewer results than requested limit, so the next
+// page is requested while there is a link to it.
+type resultsV1 struct {
+	Start int `json:"start"`
+	Size  int `json:"size"`
+	Links struct {
+		Next    string `json:"next"`
+		Context string `json:"context"`
+	} `json:"_links"`
+}
+
+// getNextStart returns start of the next page of results or -1 if there are
+// no more results.
+func (results resultsV1) getNextStart() int {
+	if results.Links.Next == "" || results.Size == 0 {
+		return -1
+	}
+
+	return results.Start + results.Size
+}
+
+// deleteResource sends DELETE request to the resource. Successful response
+// has no body, which gopencils fails to decode with EOF, so it's not
+// considered an error.
+func deleteResource(
+	resource *gopencils.Resource,
+	options ...interface{},
+) (*gopencils.Resource, error) {
+	request, err := resource.Delete(options...)
+	if err == io.EOF {
+		err = nil
+	}
+
+	return request, err
+}
+
 type User struct {
 	AccountID string `json:"accountId"`
 }
@@ -27,24 +65,48 @@ type API struct {
 	json *gopencils.Resource
 }
 
+const (
+	ContentTypePage     = `page`
+	ContentTypeBlogPost = `blogpost`
+)
+
 type PageInfo struct {
 	ID    string `json:"id"`
+	Type  string `json:"type"`
 	Title string `json:"title"`
 
 	Version struct {
 		Number int64 `json:"number"`
 	} `json:"version"`
 
-	Ancestors []struct {
-		Id    string `json:"id"`
-		Title string `json:"title"`
-	} `json:"ancestors"`
+	Ancestors []PageAncestor `json:"ancestors"`
 
 	Links struct {
 		Full string `json:"webui"`
 	} `json:"_links"`
 }
 
+type PageAncestor struct {
+	Id    string `json:"id"`
+	Title string `json:"title"`
+}
+
+type Label struct {
+	ID     string `json:"id"`
+	Prefix string `json:"prefix"`
+	Name   string `json:"name"`
+}
+
+type ContentProperty struct {
+	ID    string      `json:"id"`
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+
+	Version struct {
+		Number int64 `json:"number"`
+	} `json:"version"`
+}
+
 type AttachmentInfo struct {
 	Filename string `json:"title"`
 	ID       string `json:"id"`
@@ -58,12 +120,12 @@ type AttachmentInfo struct {
 }
 
 type form struct {
-	buffer io.Reader
+	body   io.ReadCloser
 	writer *multipart.Writer
 }
 
 func NewAPI(baseURL string, username string, password string) *API {
-	auth := &gopencils.BasicAuth{username, password}
+	auth := &gopencils.BasicAuth{Username: username, Password: password}
 
 	return &API{
 		rest: gopencils.Api(baseURL+"/rest/api", auth),
@@ -149,6 +211,8 @@ func (api *API) CreateAttachment(
 		return AttachmentInfo{}, err
 	}
 
+	defer form.body.Close()
+
 	var result struct {
 		Links struct {
 			Context string `json:"context"`
@@ -160,7 +224,7 @@ func (api *API) CreateAttachment(
 		"content/"+pageID+"/child/attachment", &result,
 	)
 
-	resource.Payload = form.buffer
+	resource.Payload = form.body
 	resource.Headers = http.Header{}
 
 	resource.SetHeader("Content-Type", form.writer.FormDataContentType())
@@ -209,6 +273,8 @@ func (api *API) UpdateAttachment(
 		return AttachmentInfo{}, err
 	}
 
+	defer form.body.Close()
+
 	var result struct {
 		Links struct {
 			Context string `json:"context"`
@@ -220,7 +286,7 @@ func (api *API) UpdateAttachment(
 		"content/"+pageID+"/child/attachment/"+attachID+"/data", &result,
 	)
 
-	resource.Payload = form.buffer
+	resource.Payload = form.body
 	resource.Headers = http.Header{}
 
 	resource.SetHeader("Content-Type", form.writer.FormDataContentType())
@@ -255,12 +321,25 @@ func (api *API) UpdateAttachment(
 	return info, nil
 }
 
-func getAttachmentPayload(name, comment, path string) (*form, error) {
-	var (
-		payload = bytes.NewBuffer(nil)
-		writer  = multipart.NewWriter(payload)
+// DeleteAttachment moves attachment to trash.
+func (api *API) DeleteAttachment(attachID string) error {
+	request, err := deleteResource(
+		api.rest.Res("content/"+attachID, &map[string]interface{}{}),
 	)
+	if err != nil {
+		return err
+	}
+
+	if request.Raw.StatusCode != 204 && request.Raw.StatusCode != 200 {
+		return newErrorStatusNotOK(request)
+	}
+
+	return nil
+}
 
+// getAttachmentPayload returns multipart form which streams given file, so
+// large files are not read into memory before uploading.
+func getAttachmentPayload(name, comment, path string) (*form, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, karma.Format(
@@ -270,11 +349,78 @@ func getAttachmentPayload(name, comment, path string) (*form, error) {
 		)
 	}
 
-	defer file.Close()
-
-	content, err := writer.CreateFormFile("file", name)
+	stat, err := file.Stat()
 	if err != nil {
+		file.Close()
+
 		return nil, karma.Format(
+			err,
+			"unable to stat file: %q",
+			path,
+		)
+	}
+
+	contentType, err := getContentType(file, name)
+	if err != nil {
+		file.Close()
+
+		return nil, err
+	}
+
+	var (
+		reader, pipe = io.Pipe()
+		writer       = multipart.NewWriter(pipe)
+	)
+
+	go func() {
+		defer file.Close()
+
+		progress := &progressReader{
+			reader: file,
+			name:   name,
+			size:   stat.Size(),
+		}
+
+		// reader side is closed by http client once request is done, so
+		// writing fails instead of blocking forever if upload is aborted
+		pipe.CloseWithError(
+			writeAttachmentPayload(
+				writer,
+				progress,
+				name,
+				contentType,
+				comment,
+			),
+		)
+	}()
+
+	return &form{
+		body:   reader,
+		writer: writer,
+	}, nil
+}
+
+func writeAttachmentPayload(
+	writer *multipart.Writer,
+	file io.Reader,
+	name string,
+	contentType string,
+	comment string,
+) error {
+	// same as multipart.CreateFormFile does, but with actual content type
+	header := textproto.MIMEHeader{}
+	header.Set(
+		"Content-Disposition",
+		fmt.Sprintf(
+			`form-data; name="file"; filename="%s"`,
+			strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name),
+		),
+	)
+	header.Set("Content-Type", contentType)
+
+	content, err := writer.CreatePart(header)
+	if err != nil {
+		return karma.Format(
 			err,
 			"unable to create form file",
 		)
@@ -282,7 +428,7 @@ func getAttachmentPayload(name, comment, path string) (*form, error) {
 
 	_, err = io.Copy(content, file)
 	if err != nil {
-		return nil, karma.Format(
+		return karma.Format(
 			err,
 			"unable to copy i/o between form-file and file",
 		)
@@ -290,7 +436,7 @@ func getAttachmentPayload(name, comment, path string) (*form, error) {
 
 	commentWriter, err := writer.CreateFormField("comment")
 	if err != nil {
-		return nil, karma.Format(
+		return karma.Format(
 			err,
 			"unable to create form field for comment",
 		)
@@ -298,7 +444,7 @@ func getAttachmentPayload(name, comment, path string) (*form, error) {
 
 	_, err = commentWriter.Write([]byte(comment))
 	if err != nil {
-		return nil, karma.Format(
+		return karma.Format(
 			err,
 			"unable to write comment in form-field",
 		)
@@ -306,50 +452,102 @@ func getAttachmentPayload(name, comment, path string) (*form, error) {
 
 	err = writer.Close()
 	if err != nil {
-		return nil, karma.Format(
+		return karma.Format(
 			err,
 			"unable to close form-writer",
 		)
 	}
 
-	return &form{
-		buffer: payload,
-		writer: writer,
-	}, nil
+	return nil
 }
 
 func (api *API) GetAttachments(pageID string) ([]AttachmentInfo, error) {
-	result := struct {
-		Links struct {
-			Context string `json:"context"`
-		} `json:"_links"`
-		Results []AttachmentInfo `json:"results"`
-	}{}
+	const limit = 100
 
-	payload := map[string]string{
-		"expand": "version,container",
+	attachments := []AttachmentInfo{}
+
+	for start := 0; start >= 0; {
+		var result struct {
+			resultsV1
+			Results []AttachmentInfo `json:"results"`
+		}
+
+		request, err := api.rest.Res(
+			"content/"+pageID+"/child/attachment", &result,
+		).Get(map[string]string{
+			"expand": "version,container",
+			"start":  fmt.Sprint(start),
+			"limit":  fmt.Sprint(limit),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if request.Raw.StatusCode != 200 {
+			return nil, newErrorStatusNotOK(request)
+		}
+
+		for _, info := range result.Results {
+			if info.Links.Context == "" {
+				info.Links.Context = result.Links.Context
+			}
+
+			attachments = append(attachments, info)
+		}
+
+		start = result.getNextStart()
 	}
 
-	request, err := api.rest.Res(
-		"content/"+pageID+"/child/attachment", &result,
-	).Get(payload)
+	return attachments, nil
+}
+
+// DownloadAttachment writes contents of the attachment to given writer.
+// Download link of the attachment is relative to context path of Confluence
+// instance, which is the same for REST API v1 and v2.
+func (api *API) DownloadAttachment(
+	attachment AttachmentInfo,
+	writer io.Writer,
+) error {
+	base := api.rest.Api.BaseUrl
+
+	link := base.Scheme + "://" + base.Host +
+		attachment.Links.Context + attachment.Links.Download
+
+	request, err := http.NewRequest(http.MethodGet, link, nil)
 	if err != nil {
-		return nil, err
+		return karma.Format(err, "unable to create request: %q", link)
 	}
 
-	if request.Raw.StatusCode != 200 {
-		return nil, newErrorStatusNotOK(request)
+	if auth := api.rest.Api.BasicAuth; auth != nil {
+		request.SetBasicAuth(auth.Username, auth.Password)
 	}
 
-	for i, info := range result.Results {
-		if info.Links.Context == "" {
-			info.Links.Context = result.Links.Context
-		}
+	response, err := api.rest.Api.Client.Do(request)
+	if err != nil {
+		return err
+	}
 
-		result.Results[i] = info
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		return fmt.Errorf(
+			"Confluence returned unexpected status: %v, "+
+				"while downloading attachment %q",
+			response.Status,
+			attachment.Filename,
+		)
+	}
+
+	_, err = io.Copy(writer, response.Body)
+	if err != nil {
+		return karma.Format(
+			err,
+			"unable to download attachment %q",
+			attachment.Filename,
+		)
 	}
 
-	return result.Results, nil
+	return nil
 }
 
 func (api *API) GetPageByID(pageID string) (*PageInfo, error) {
@@ -367,14 +565,101 @@ func (api *API) GetPageByID(pageID string) (*PageInfo, error) {
 	return request.Response.(*PageInfo), nil
 }
 
+func (api *API) GetPageBody(pageID string, version int64) (string, error) {
+	var page struct {
+		Body struct {
+			Storage struct {
+				Value string `json:"value"`
+			} `json:"storage"`
+		} `json:"body"`
+	}
+
+	payload := map[string]string{"expand": "body.storage"}
+	if version != 0 {
+		payload["status"] = "historical"
+		payload["version"] = fmt.Sprint(version)
+	}
+
+	request, err := api.rest.Res(
+		"content/"+pageID, &page,
+	).Get(payload)
+	if err != nil {
+		return "", err
+	}
+
+	if request.Raw.StatusCode != 200 {
+		return "", newErrorStatusNotOK(request)
+	}
+
+	return page.Body.Storage.Value, nil
+}
+
+// FindBlogPost returns blog post with given title. If date is specified in
+// YYYY-MM-DD format, only blog posts posted at that day are considered.
+func (api *API) FindBlogPost(
+	space string,
+	title string,
+	date string,
+) (*PageInfo, error) {
+	result := struct {
+		Results []PageInfo `json:"results"`
+	}{}
+
+	payload := map[string]string{
+		"type":     ContentTypeBlogPost,
+		"spaceKey": space,
+		"title":    title,
+		"expand":   "version",
+	}
+
+	if date != "" {
+		payload["postingDay"] = date
+	}
+
+	request, err := api.rest.Res(
+		"content/", &result,
+	).Get(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Raw.StatusCode != 404 && request.Raw.StatusCode != 200 {
+		return nil, newErrorStatusNotOK(request)
+	}
+
+	if len(result.Results) == 0 {
+		return nil, nil
+	}
+
+	return &result.Results[0], nil
+}
+
 func (api *API) CreatePage(
 	space string,
 	parent *PageInfo,
 	title string,
 	body string,
+) (*PageInfo, error) {
+	return api.createContent(ContentTypePage, space, parent, title, body)
+}
+
+func (api *API) CreateBlogPost(
+	space string,
+	title string,
+	body string,
+) (*PageInfo, error) {
+	return api.createContent(ContentTypeBlogPost, space, nil, title, body)
+}
+
+func (api *API) createContent(
+	contentType string,
+	space string,
+	parent *PageInfo,
+	title string,
+	body string,
 ) (*PageInfo, error) {
 	payload := map[string]interface{}{
-		"type":  "page",
+		"type":  contentType,
 		"title": title,
 		"space": map[string]interface{}{
 			"key": space,
@@ -386,11 +671,11 @@ func (api *API) CreatePage(
 			},
 		},
 		"metadata": map[string]interface{}{
-		  "properties": map[string]interface{}{
-		    "editor": map[string]interface{}{
-		       "value": "v2",
-		    },
-		  },
+			"properties": map[string]interface{}{
+				"editor": map[string]interface{}{
+					"value": "v2",
+				},
+			},
 		},
 	}
 
@@ -419,27 +704,19 @@ func (api *API) UpdatePage(
 ) error {
 	nextPageVersion := page.Version.Number + 1
 
-	if len(page.Ancestors) == 0 {
-		return fmt.Errorf(
-			"page %q info does not contain any information about parents",
-			page.ID,
-		)
-	}
-
-	// picking only the last one, which is required by confluence
-	oldAncestors := []map[string]interface{}{
-		{"id": page.Ancestors[len(page.Ancestors)-1].Id},
+	contentType := page.Type
+	if contentType == "" {
+		contentType = ContentTypePage
 	}
 
 	payload := map[string]interface{}{
 		"id":    page.ID,
-		"type":  "page",
+		"type":  contentType,
 		"title": page.Title,
 		"version": map[string]interface{}{
 			"number":    nextPageVersion,
 			"minorEdit": false,
 		},
-		"ancestors": oldAncestors,
 		"body": map[string]interface{}{
 			"storage": map[string]interface{}{
 				"value":          string(newContent),
@@ -448,6 +725,15 @@ func (api *API) UpdatePage(
 		},
 	}
 
+	// blog posts are not part of page tree, and home page of the space has
+	// no parents, so it's kept in place
+	if contentType == ContentTypePage && len(page.Ancestors) > 0 {
+		// picking only the last one, which is required by confluence
+		payload["ancestors"] = []map[string]interface{}{
+			{"id": page.Ancestors[len(page.Ancestors)-1].Id},
+		}
+	}
+
 	request, err := api.rest.Res(
 		"content/"+page.ID, &map[string]interface{}{},
 	).Put(payload)
@@ -459,6 +745,295 @@ func (api *API) UpdatePage(
 		return newErrorStatusNotOK(request)
 	}
 
+	page.Version.Number = nextPageVersion
+
+	return nil
+}
+
+func (api *API) GetChildPages(pageID string) ([]PageInfo, error) {
+	const limit = 100
+
+	pages := []PageInfo{}
+
+	for start := 0; start >= 0; {
+		var result struct {
+			resultsV1
+			Results []PageInfo `json:"results"`
+		}
+
+		request, err := api.rest.Res(
+			"content/"+pageID+"/child/page", &result,
+		).Get(map[string]string{
+			"expand": "version",
+			"start":  fmt.Sprint(start),
+			"limit":  fmt.Sprint(limit),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if request.Raw.StatusCode != 200 {
+			return nil, newErrorStatusNotOK(request)
+		}
+
+		pages = append(pages, result.Results...)
+
+		start = result.getNextStart()
+	}
+
+	return pages, nil
+}
+
+func (api *API) DeletePage(pageID string) error {
+	request, err := deleteResource(
+		api.rest.Res("content/"+pageID, &map[string]interface{}{}),
+	)
+	if err != nil {
+		return err
+	}
+
+	if request.Raw.StatusCode != 204 && request.Raw.StatusCode != 200 {
+		return newErrorStatusNotOK(request)
+	}
+
+	return nil
+}
+
+// ArchivePage requests archiving of page. Archiving is performed by
+// Confluence asynchronously and is available only on Confluence Cloud.
+func (api *API) ArchivePage(pageID string) error {
+	request, err := api.rest.Res(
+		"content/archive", &map[string]interface{}{},
+	).Post(map[string]interface{}{
+		"pages": []map[string]interface{}{
+			{"id": pageID},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if request.Raw.StatusCode != 202 && request.Raw.StatusCode != 200 {
+		return newErrorStatusNotOK(request)
+	}
+
+	return nil
+}
+
+func (api *API) GetPageLabels(pageID string) ([]Label, error) {
+	const limit = 100
+
+	labels := []Label{}
+
+	for start := 0; start >= 0; {
+		var result struct {
+			resultsV1
+			Results []Label `json:"results"`
+		}
+
+		request, err := api.rest.Res(
+			"content/"+pageID+"/label", &result,
+		).Get(map[string]string{
+			"start": fmt.Sprint(start),
+			"limit": fmt.Sprint(limit),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if request.Raw.StatusCode != 200 {
+			return nil, newErrorStatusNotOK(request)
+		}
+
+		labels = append(labels, result.Results...)
+
+		start = result.getNextStart()
+	}
+
+	return labels, nil
+}
+
+func (api *API) AddPageLabels(pageID string, labels []string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	payload := []map[string]interface{}{}
+	for _, label := range labels {
+		payload = append(payload, map[string]interface{}{
+			"prefix": "global",
+			"name":   label,
+		})
+	}
+
+	request, err := api.rest.Res(
+		"content/"+pageID+"/label", &map[string]interface{}{},
+	).Post(payload)
+	if err != nil {
+		return err
+	}
+
+	if request.Raw.StatusCode != 200 {
+		return newErrorStatusNotOK(request)
+	}
+
+	return nil
+}
+
+func (api *API) RemovePageLabel(pageID string, label string) error {
+	request, err := deleteResource(
+		api.rest.Res("content/"+pageID+"/label", &map[string]interface{}{}),
+		map[string]string{"name": label},
+	)
+	if err != nil {
+		return err
+	}
+
+	// label may be already removed manually
+	if request.Raw.StatusCode == 404 {
+		return nil
+	}
+
+	if request.Raw.StatusCode != 204 && request.Raw.StatusCode != 200 {
+		return newErrorStatusNotOK(request)
+	}
+
+	return nil
+}
+
+func (api *API) FindPagesByLabel(
+	space string,
+	label string,
+) ([]PageInfo, error) {
+	const limit = 100
+
+	pages := []PageInfo{}
+
+	for start := 0; start >= 0; {
+		var result struct {
+			resultsV1
+			Results []PageInfo `json:"results"`
+		}
+
+		request, err := api.rest.Res(
+			"content/search", &result,
+		).Get(map[string]string{
+			"cql": fmt.Sprintf(
+				"type in (page, blogpost) and space = %q and label = %q",
+				space,
+				label,
+			),
+			"expand": "ancestors,version",
+			"start":  fmt.Sprint(start),
+			"limit":  fmt.Sprint(limit),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if request.Raw.StatusCode != 200 {
+			return nil, newErrorStatusNotOK(request)
+		}
+
+		pages = append(pages, result.Results...)
+
+		start = result.getNextStart()
+	}
+
+	return pages, nil
+}
+
+// GetContentProperty returns property of page or attachment with given key
+// or nil if there is no such property.
+func (api *API) GetContentProperty(
+	contentID string,
+	key string,
+) (*ContentProperty, error) {
+	request, err := api.rest.Res(
+		"content/"+contentID+"/property/"+key, &ContentProperty{},
+	).Get()
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Raw.StatusCode == 404 {
+		return nil, nil
+	}
+
+	if request.Raw.StatusCode != 200 {
+		return nil, newErrorStatusNotOK(request)
+	}
+
+	return request.Response.(*ContentProperty), nil
+}
+
+// SetContentProperty creates or updates property of page or attachment.
+// Property is not updated if it already has given value.
+func (api *API) SetContentProperty(
+	contentID string,
+	key string,
+	value interface{},
+) error {
+	property, err := api.GetContentProperty(contentID, key)
+	if err != nil {
+		return err
+	}
+
+	if property != nil && reflect.DeepEqual(property.Value, value) {
+		return nil
+	}
+
+	payload := map[string]interface{}{
+		"key":   key,
+		"value": value,
+	}
+
+	var request *gopencils.Resource
+
+	if property == nil {
+		request, err = api.rest.Res(
+			"content/"+contentID+"/property", &ContentProperty{},
+		).Post(payload)
+	} else {
+		payload["version"] = map[string]interface{}{
+			"number": property.Version.Number + 1,
+		}
+
+		request, err = api.rest.Res(
+			"content/"+contentID+"/property/"+key, &ContentProperty{},
+		).Put(payload)
+	}
+	if err != nil {
+		return err
+	}
+
+	if request.Raw.StatusCode != 200 {
+		return newErrorStatusNotOK(request)
+	}
+
+	return nil
+}
+
+// DeleteContentProperty removes property of page or attachment, missing
+// property is not considered an error.
+func (api *API) DeleteContentProperty(contentID string, key string) error {
+	request, err := deleteResource(
+		api.rest.Res(
+			"content/"+contentID+"/property/"+key, &map[string]interface{}{},
+		),
+	)
+	if err != nil {
+		return err
+	}
+
+	if request.Raw.StatusCode == 404 {
+		return nil
+	}
+
+	if request.Raw.StatusCode != 204 && request.Raw.StatusCode != 200 {
+		return newErrorStatusNotOK(request)
+	}
+
 	return nil
 }
 
@@ -586,7 +1161,7 @@ func (api *API) RestrictPageUpdates(
 ) error {
 	var err error
 
-	if strings.HasSuffix(api.rest.Api.BaseUrl.Host, "atlassian.net") {
+	if isCloud(api.rest.Api.BaseUrl.Host) {
 		err = api.RestrictPageUpdatesCloud(page, allowedUser)
 	} else {
 		err = api.RestrictPageUpdatesServer(page, allowedUser)
@@ -0,0 +1,797 @@
+package confluence
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func newTestAPI(t *testing.T) (*API, *fixtureServer) {
+	server := newFixtureServer(t)
+
+	return NewAPI(server.URL, "jdoe", "secret"), server
+}
+
+// decodePayload decodes JSON body of recorded request.
+func decodePayload(t *testing.T, request fixtureRequest) interface{} {
+	var payload interface{}
+
+	err := json.Unmarshal(request.body, &payload)
+	if err != nil {
+		t.Fatalf("request body is not valid JSON: %s: %s", err, request.body)
+	}
+
+	return payload
+}
+
+// lookup returns value under given path of keys in decoded JSON payload.
+func lookup(payload interface{}, keys ...interface{}) interface{} {
+	for _, key := range keys {
+		switch key := key.(type) {
+		case string:
+			object, ok := payload.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+
+			payload = object[key]
+
+		case int:
+			array, ok := payload.([]interface{})
+			if !ok || key >= len(array) {
+				return nil
+			}
+
+			payload = array[key]
+		}
+	}
+
+	return payload
+}
+
+func assertEqual(t *testing.T, expected, actual interface{}) {
+	t.Helper()
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected %#v, got %#v", expected, actual)
+	}
+}
+
+func assertNoError(t *testing.T, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestAPI_FindPage(t *testing.T) {
+	api, server := newTestAPI(t)
+
+	server.on(
+		"GET", "/rest/api/content/", query{"title": "Guide"},
+		200, "v1/content-guide.json",
+	)
+	server.on(
+		"GET", "/rest/api/content/", query{"title": "Missing"},
+		200, "v1/content-empty.json",
+	)
+
+	page, err := api.FindPage("DOC", "Guide")
+	assertNoError(t, err)
+
+	assertEqual(t, "123", page.ID)
+	assertEqual(t, "Guide", page.Title)
+	assertEqual(t, int64(7), page.Version.Number)
+	assertEqual(t, "/display/DOC/Guide", page.Links.Full)
+	assertEqual(t, []PageAncestor{
+		{Id: "100", Title: "Home"},
+		{Id: "110", Title: "Docs"},
+	}, page.Ancestors)
+
+	request := server.request("GET", "/rest/api/content/")
+	assertEqual(t, "DOC", request.query.Get("spaceKey"))
+	assertEqual(t, "ancestors,version", request.query.Get("expand"))
+
+	username, password, ok := (&http.Request{
+		Header: request.header,
+	}).BasicAuth()
+	assertEqual(t, true, ok)
+	assertEqual(t, "jdoe", username)
+	assertEqual(t, "secret", password)
+
+	page, err = api.FindPage("DOC", "Missing")
+	assertNoError(t, err)
+
+	if page != nil {
+		t.Fatalf("expected no page, got %#v", page)
+	}
+}
+
+func TestAPI_FindRootPage(t *testing.T) {
+	api, server := newTestAPI(t)
+
+	server.on(
+		"GET", "/rest/api/content/", query{"spaceKey": "DOC"},
+		200, "v1/content-guide.json",
+	)
+
+	page, err := api.FindRootPage("DOC")
+	assertNoError(t, err)
+
+	assertEqual(t, "100", page.ID)
+	assertEqual(t, "Home", page.Title)
+
+	request := server.request("GET", "/rest/api/content/")
+	if _, ok := request.query["title"]; ok {
+		t.Fatalf("root page lookup should not filter by title")
+	}
+}
+
+func TestAPI_GetPageByID(t *testing.T) {
+	api, server := newTestAPI(t)
+
+	server.on(
+		"GET", "/rest/api/content/123", query{"expand": "ancestors,version"},
+		200, "v1/page.json",
+	)
+	server.on("GET", "/rest/api/content/404", nil, 404, "")
+
+	page, err := api.GetPageByID("123")
+	assertNoError(t, err)
+
+	assertEqual(t, "Guide", page.Title)
+	assertEqual(t, ContentTypePage, page.Type)
+	assertEqual(t, 2, len(page.Ancestors))
+
+	_, err = api.GetPageByID("404")
+	if err == nil {
+		t.Fatalf("expected error for missing page")
+	}
+}
+
+func TestAPI_GetPageBody(t *testing.T) {
+	api, server := newTestAPI(t)
+
+	server.on(
+		"GET", "/rest/api/content/123", query{"version": "3"},
+		200, "v1/page-body-historical.json",
+	)
+	server.on(
+		"GET", "/rest/api/content/123", query{"expand": "body.storage"},
+		200, "v1/page-body.json",
+	)
+
+	body, err := api.GetPageBody("123", 0)
+	assertNoError(t, err)
+	assertEqual(t, "<p>current body</p>", body)
+
+	body, err = api.GetPageBody("123", 3)
+	assertNoError(t, err)
+	assertEqual(t, "<p>body of version 3</p>", body)
+
+	request := server.request("GET", "/rest/api/content/123")
+	assertEqual(t, "historical", request.query.Get("status"))
+}
+
+func TestAPI_CreatePage(t *testing.T) {
+	api, server := newTestAPI(t)
+
+	server.on("POST", "/rest/api/content/", nil, 200, "v1/page-created.json")
+
+	parent := &PageInfo{ID: "100", Title: "Home"}
+
+	page, err := api.CreatePage("DOC", parent, "New Page", "<p>new</p>")
+	assertNoError(t, err)
+
+	assertEqual(t, "124", page.ID)
+	assertEqual(t, int64(1), page.Version.Number)
+	assertEqual(t, []PageAncestor{{Id: "100", Title: "Home"}}, page.Ancestors)
+
+	payload := decodePayload(t, server.request("POST", "/rest/api/content/"))
+	assertEqual(t, "page", lookup(payload, "type"))
+	assertEqual(t, "New Page", lookup(payload, "title"))
+	assertEqual(t, "DOC", lookup(payload, "space", "key"))
+	assertEqual(t, "100", lookup(payload, "ancestors", 0, "id"))
+	assertEqual(t, "<p>new</p>", lookup(payload, "body", "storage", "value"))
+	assertEqual(
+		t, "v2",
+		lookup(payload, "metadata", "properties", "editor", "value"),
+	)
+}
+
+func TestAPI_FindBlogPost(t *testing.T) {
+	api, server := newTestAPI(t)
+
+	server.on(
+		"GET", "/rest/api/content/", query{"type": "blogpost"},
+		200, "v1/blogposts.json",
+	)
+
+	post, err := api.FindBlogPost("DOC", "Release Notes", "2020-03-01")
+	assertNoError(t, err)
+
+	assertEqual(t, "456", post.ID)
+	assertEqual(t, ContentTypeBlogPost, post.Type)
+
+	request := server.request("GET", "/rest/api/content/")
+	assertEqual(t, "2020-03-01", request.query.Get("postingDay"))
+	assertEqual(t, "Release Notes", request.query.Get("title"))
+}
+
+func TestAPI_CreateBlogPost(t *testing.T) {
+	api, server := newTestAPI(t)
+
+	server.on(
+		"POST", "/rest/api/content/", nil, 200, "v1/blogpost-created.json",
+	)
+
+	post, err := api.CreateBlogPost("DOC", "Announcement", "<p>news</p>")
+	assertNoError(t, err)
+
+	assertEqual(t, "457", post.ID)
+	assertEqual(t, ContentTypeBlogPost, post.Type)
+
+	payload := decodePayload(t, server.request("POST", "/rest/api/content/"))
+	assertEqual(t, "blogpost", lookup(payload, "type"))
+	assertEqual(t, nil, lookup(payload, "ancestors"))
+}
+
+func TestAPI_UpdatePage(t *testing.T) {
+	api, server := newTestAPI(t)
+
+	server.on("PUT", "/rest/api/content/123", nil, 200, "v1/page-updated.json")
+
+	page := &PageInfo{
+		ID:    "123",
+		Type:  ContentTypePage,
+		Title: "Guide",
+		Ancestors: []PageAncestor{
+			{Id: "100", Title: "Home"},
+			{Id: "110", Title: "Docs"},
+		},
+	}
+	page.Version.Number = 7
+
+	err := api.UpdatePage(page, "<p>updated</p>")
+	assertNoError(t, err)
+
+	assertEqual(t, int64(8), page.Version.Number)
+
+	payload := decodePayload(t, server.request("PUT", "/rest/api/content/123"))
+	assertEqual(t, float64(8), lookup(payload, "version", "number"))
+	assertEqual(t, "110", lookup(payload, "ancestors", 0, "id"))
+	assertEqual(t, nil, lookup(payload, "ancestors", 1))
+	assertEqual(
+		t, "<p>updated</p>",
+		lookup(payload, "body", "storage", "value"),
+	)
+
+	// home page of the space has no parents and is kept in place
+	home := &PageInfo{ID: "123", Type: ContentTypePage, Title: "Home"}
+	home.Version.Number = 8
+
+	assertNoError(t, api.UpdatePage(home, "<p>home</p>"))
+
+	payload = decodePayload(t, server.request("PUT", "/rest/api/content/123"))
+	assertEqual(t, "Home", lookup(payload, "title"))
+	assertEqual(t, nil, lookup(payload, "ancestors"))
+}
+
+func TestAPI_RestrictPageUpdates(t *testing.T) {
+	api, server := newTestAPI(t)
+
+	server.on(
+		"POST", "/rpc/json-rpc/confluenceservice-v2/setContentPermissions",
+		nil, 200, "v1/json-rpc-true.json",
+	)
+
+	err := api.RestrictPageUpdates(&PageInfo{ID: "123"}, "jdoe")
+	assertNoError(t, err)
+
+	payload := decodePayload(t, server.request(
+		"POST", "/rpc/json-rpc/confluenceservice-v2/setContentPermissions",
+	))
+	assertEqual(t, "123", lookup(payload, 0))
+	assertEqual(t, "Edit", lookup(payload, 1))
+	assertEqual(t, "jdoe", lookup(payload, 2, "userName"))
+}
+
+func TestAPI_GetChildPages(t *testing.T) {
+	api, server := newTestAPI(t)
+
+	server.on(
+		"GET", "/rest/api/content/110/child/page", query{"start": "0"},
+		200, "v1/children.json",
+	)
+
+	pages, err := api.GetChildPages("110")
+	assertNoError(t, err)
+
+	assertEqual(t, 2, len(pages))
+	assertEqual(t, "Guide", pages[0].Title)
+	assertEqual(t, "FAQ", pages[1].Title)
+	assertEqual(t, int64(7), pages[0].Version.Number)
+}
+
+func TestAPI_GetChildPages_CappedLimit(t *testing.T) {
+	api, server := newTestAPI(t)
+
+	// server returns fewer results than requested, but links next page
+	server.on(
+		"GET", "/rest/api/content/110/child/page", query{"start": "0"},
+		200, "v1/children-first.json",
+	)
+	server.on(
+		"GET", "/rest/api/content/110/child/page", query{"start": "2"},
+		200, "v1/children-last.json",
+	)
+
+	pages, err := api.GetChildPages("110")
+	assertNoError(t, err)
+
+	assertEqual(t, 3, len(pages))
+	assertEqual(t, "Changelog", pages[2].Title)
+	assertEqual(
+		t, 2, server.count("GET", "/rest/api/content/110/child/page"),
+	)
+}
+
+func TestAPI_DeletePage(t *testing.T) {
+	api, server := newTestAPI(t)
+
+	server.on("DELETE", "/rest/api/content/123", nil, 204, "")
+	server.on("DELETE", "/rest/api/content/404", nil, 404, "")
+
+	assertNoError(t, api.DeletePage("123"))
+
+	if api.DeletePage("404") == nil {
+		t.Fatalf("expected error for missing page")
+	}
+}
+
+func TestAPI_ArchivePage(t *testing.T) {
+	api, server := newTestAPI(t)
+
+	server.on("POST", "/rest/api/content/archive", nil, 202, "v1/archive.json")
+
+	assertNoError(t, api.ArchivePage("123"))
+
+	payload := decodePayload(
+		t, server.request("POST", "/rest/api/content/archive"),
+	)
+	assertEqual(t, "123", lookup(payload, "pages", 0, "id"))
+}
+
+func TestAPI_GetPageLabels(t *testing.T) {
+	api, server := newTestAPI(t)
+
+	server.on(
+		"GET", "/rest/api/content/123/label", query{"start": "0"},
+		200, "v1/labels.json",
+	)
+
+	labels, err := api.GetPageLabels("123")
+	assertNoError(t, err)
+
+	assertEqual(t, []Label{
+		{ID: "9001", Prefix: "global", Name: "docs"},
+		{ID: "9002", Prefix: "global", Name: "mark-root"},
+	}, labels)
+}
+
+func TestAPI_AddPageLabels(t *testing.T) {
+	api, server := newTestAPI(t)
+
+	server.on(
+		"POST", "/rest/api/content/123/label", nil, 200, "v1/labels-added.json",
+	)
+
+	assertNoError(t, api.AddPageLabels("123", nil))
+	assertEqual(t, 0, server.count("POST", "/rest/api/content/123/label"))
+
+	assertNoError(t, api.AddPageLabels("123", []string{"docs", "howto"}))
+
+	payload := decodePayload(
+		t, server.request("POST", "/rest/api/content/123/label"),
+	)
+	assertEqual(t, "global", lookup(payload, 0, "prefix"))
+	assertEqual(t, "docs", lookup(payload, 0, "name"))
+	assertEqual(t, "howto", lookup(payload, 1, "name"))
+}
+
+func TestAPI_RemovePageLabel(t *testing.T) {
+	api, server := newTestAPI(t)
+
+	server.on(
+		"DELETE", "/rest/api/content/123/label", query{"name": "docs"}, 204, "",
+	)
+	server.on(
+		"DELETE", "/rest/api/content/123/label", query{"name": "gone"}, 404, "",
+	)
+
+	assertNoError(t, api.RemovePageLabel("123", "docs"))
+	assertNoError(t, api.RemovePageLabel("123", "gone"))
+	assertEqual(t, 2, server.count("DELETE", "/rest/api/content/123/label"))
+}
+
+func TestAPI_FindPagesByLabel(t *testing.T) {
+	api, server := newTestAPI(t)
+
+	server.on(
+		"GET", "/rest/api/content/search", nil, 200, "v1/search-label.json",
+	)
+
+	pages, err := api.FindPagesByLabel("DOC", "mark-root")
+	assertNoError(t, err)
+
+	assertEqual(t, 1, len(pages))
+	assertEqual(t, "Docs", pages[0].Title)
+	assertEqual(t, []PageAncestor{{Id: "100", Title: "Home"}}, pages[0].Ancestors)
+
+	request := server.request("GET", "/rest/api/content/search")
+	assertEqual(
+		t,
+		`type in (page, blogpost) and space = "DOC" and label = "mark-root"`,
+		request.query.Get("cql"),
+	)
+}
+
+func TestAPI_GetContentProperty(t *testing.T) {
+	api, server := newTestAPI(t)
+
+	server.on(
+		"GET", "/rest/api/content/123/property/mark-source-path", nil,
+		200, "v1/property.json",
+	)
+	server.on(
+		"GET", "/rest/api/content/123/property/missing", nil, 404, "",
+	)
+
+	property, err := api.GetContentProperty("123", "mark-source-path")
+	assertNoError(t, err)
+
+	assertEqual(t, "docs/guide.md", property.Value)
+	assertEqual(t, int64(2), property.Version.Number)
+
+	property, err = api.GetContentProperty("123", "missing")
+	assertNoError(t, err)
+
+	if property != nil {
+		t.Fatalf("expected no property, got %#v", property)
+	}
+}
+
+func TestAPI_SetContentProperty(t *testing.T) {
+	api, server := newTestAPI(t)
+
+	server.on(
+		"GET", "/rest/api/content/123/property/mark-source-path", nil,
+		200, "v1/property.json",
+	)
+	server.on(
+		"PUT", "/rest/api/content/123/property/mark-source-path", nil,
+		200, "v1/property.json",
+	)
+	server.on("GET", "/rest/api/content/123/property/new", nil, 404, "")
+	server.on(
+		"POST", "/rest/api/content/123/property", nil, 200, "v1/property.json",
+	)
+
+	// same value is not written again
+	err := api.SetContentProperty("123", "mark-source-path", "docs/guide.md")
+	assertNoError(t, err)
+	assertEqual(
+		t, 0,
+		server.count("PUT", "/rest/api/content/123/property/mark-source-path"),
+	)
+
+	err = api.SetContentProperty("123", "mark-source-path", "docs/moved.md")
+	assertNoError(t, err)
+
+	payload := decodePayload(t, server.request(
+		"PUT", "/rest/api/content/123/property/mark-source-path",
+	))
+	assertEqual(t, "docs/moved.md", lookup(payload, "value"))
+	assertEqual(t, float64(3), lookup(payload, "version", "number"))
+
+	err = api.SetContentProperty("123", "new", map[string]interface{}{
+		"a": "b",
+	})
+	assertNoError(t, err)
+
+	payload = decodePayload(
+		t, server.request("POST", "/rest/api/content/123/property"),
+	)
+	assertEqual(t, "new", lookup(payload, "key"))
+	assertEqual(t, "b", lookup(payload, "value", "a"))
+}
+
+func TestAPI_DeleteContentProperty(t *testing.T) {
+	api, server := newTestAPI(t)
+
+	server.on(
+		"DELETE", "/rest/api/content/123/property/emoji-title-published", nil,
+		204, "",
+	)
+	server.on(
+		"DELETE", "/rest/api/content/123/property/emoji-title-draft", nil,
+		404, "",
+	)
+
+	assertNoError(t, api.DeleteContentProperty("123", "emoji-title-published"))
+	assertNoError(t, api.DeleteContentProperty("123", "emoji-title-draft"))
+}
+
+func TestAPI_GetAttachments(t *testing.T) {
+	api, server := newTestAPI(t)
+
+	server.on(
+		"GET", "/rest/api/content/123/child/attachment", query{"start": "0"},
+		200, "v1/attachments.json",
+	)
+
+	attachments, err := api.GetAttachments("123")
+	assertNoError(t, err)
+
+	assertEqual(t, 1, len(attachments))
+	assertEqual(t, "att1", attachments[0].ID)
+	assertEqual(t, "img.png", attachments[0].Filename)
+	assertEqual(t, "uploaded by mark", attachments[0].Metadata.Comment)
+	assertEqual(t, "/confluence", attachments[0].Links.Context)
+}
+
+func TestAPI_CreateAttachment(t *testing.T) {
+	api, server := newTestAPI(t)
+
+	server.on(
+		"POST", "/rest/api/content/123/child/attachment", nil,
+		200, "v1/attachment-created.json",
+	)
+
+	attachment, err := api.CreateAttachment(
+		"123", "diagram.svg", "abc123", filepath.Join("testdata", "v1", "img.png"),
+	)
+	assertNoError(t, err)
+
+	assertEqual(t, "att2", attachment.ID)
+	assertEqual(t, "/confluence", attachment.Links.Context)
+
+	request := server.request("POST", "/rest/api/content/123/child/attachment")
+	assertEqual(t, "no-check", request.header.Get("X-Atlassian-Token"))
+
+	fields := readMultipart(t, request)
+	assertEqual(t, "png", fields["file"])
+	assertEqual(t, "abc123", fields["comment"])
+}
+
+func TestAPI_CreateAttachment_Large(t *testing.T) {
+	const size = 64 << 20
+
+	dir, err := ioutil.TempDir("", "mark-test-")
+	assertNoError(t, err)
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "large.bin")
+
+	file, err := os.Create(path)
+	assertNoError(t, err)
+
+	// sparse file, so test doesn't actually write it to disk
+	assertNoError(t, file.Truncate(size))
+	assertNoError(t, file.Close())
+
+	response, err := ioutil.ReadFile(
+		filepath.Join("testdata", "v1", "attachment-created.json"),
+	)
+	assertNoError(t, err)
+
+	type upload struct {
+		token    string
+		length   int64
+		filename string
+		received int64
+		comment  string
+	}
+
+	uploads := make(chan upload, 1)
+
+	// request body is read part by part instead of recording it like
+	// fixtureServer does, so the test itself doesn't hold the whole file
+	server := httptest.NewServer(http.HandlerFunc(
+		func(writer http.ResponseWriter, request *http.Request) {
+			result := upload{
+				token:  request.Header.Get("X-Atlassian-Token"),
+				length: request.ContentLength,
+			}
+
+			reader, err := request.MultipartReader()
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			for {
+				part, err := reader.NextPart()
+				if err == io.EOF {
+					break
+				}
+
+				if err != nil {
+					http.Error(writer, err.Error(), http.StatusBadRequest)
+					return
+				}
+
+				switch part.FormName() {
+				case "file":
+					result.filename = part.FileName()
+					result.received, err = io.Copy(ioutil.Discard, part)
+				case "comment":
+					var comment []byte
+					comment, err = ioutil.ReadAll(part)
+					result.comment = string(comment)
+				}
+
+				if err != nil {
+					http.Error(writer, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+
+			uploads <- result
+
+			writer.Header().Set("Content-Type", "application/json")
+			writer.Write(response)
+		},
+	))
+
+	t.Cleanup(server.Close)
+
+	api := NewAPI(server.URL, "jdoe", "secret")
+
+	runtime.GC()
+
+	var before, after runtime.MemStats
+
+	runtime.ReadMemStats(&before)
+
+	_, err = api.CreateAttachment("123", "large.bin", "big one", path)
+	assertNoError(t, err)
+
+	runtime.ReadMemStats(&after)
+
+	result := <-uploads
+
+	assertEqual(t, "no-check", result.token)
+	assertEqual(t, "large.bin", result.filename)
+	assertEqual(t, int64(size), result.received)
+	assertEqual(t, "big one", result.comment)
+
+	// body is streamed with chunked encoding, so its length is unknown
+	// beforehand
+	assertEqual(t, int64(-1), result.length)
+
+	allocated := after.TotalAlloc - before.TotalAlloc
+	if allocated > size/4 {
+		t.Fatalf(
+			"uploading %d bytes allocated %d bytes, file should be streamed",
+			size,
+			allocated,
+		)
+	}
+}
+
+func TestAPI_UpdateAttachment(t *testing.T) {
+	api, server := newTestAPI(t)
+
+	server.on(
+		"POST", "/rest/api/content/123/child/attachment/att1/data", nil,
+		200, "v1/attachment-updated.json",
+	)
+
+	attachment, err := api.UpdateAttachment(
+		"123", "att1", "img.png", "def456",
+		filepath.Join("testdata", "v1", "img.png"),
+	)
+	assertNoError(t, err)
+
+	assertEqual(t, "att1", attachment.ID)
+	assertEqual(t, "def456", attachment.Metadata.Comment)
+
+	request := server.request(
+		"POST", "/rest/api/content/123/child/attachment/att1/data",
+	)
+	assertEqual(t, "no-check", request.header.Get("X-Atlassian-Token"))
+	assertEqual(t, "def456", readMultipart(t, request)["comment"])
+}
+
+func TestAPI_DeleteAttachment(t *testing.T) {
+	api, server := newTestAPI(t)
+
+	server.on("DELETE", "/rest/api/content/att1", nil, 204, "")
+
+	assertNoError(t, api.DeleteAttachment("att1"))
+	assertEqual(t, 1, server.count("DELETE", "/rest/api/content/att1"))
+}
+
+func TestAPI_DownloadAttachment(t *testing.T) {
+	api, server := newTestAPI(t)
+
+	server.on(
+		"GET", "/confluence/download/attachments/123/img.png", nil,
+		200, "v1/img.png",
+	)
+
+	var attachment AttachmentInfo
+	attachment.Filename = "img.png"
+	attachment.Links.Context = "/confluence"
+	attachment.Links.Download = "/download/attachments/123/img.png?version=1"
+
+	var buffer bytes.Buffer
+
+	err := api.DownloadAttachment(attachment, &buffer)
+	assertNoError(t, err)
+
+	assertEqual(t, "png", buffer.String())
+
+	request := server.request(
+		"GET", "/confluence/download/attachments/123/img.png",
+	)
+	assertEqual(t, "1", request.query.Get("version"))
+}
+
+func TestAPI_GetUserByName(t *testing.T) {
+	api, server := newTestAPI(t)
+
+	server.on("GET", "/rest/api/search/user", nil, 200, "v1/user.json")
+
+	user, err := api.GetUserByName("John Doe")
+	assertNoError(t, err)
+
+	assertEqual(t, "5b10ac8d82e05b22cc7d4ef5", user.AccountID)
+
+	request := server.request("GET", "/rest/api/search/user")
+	assertEqual(t, `user.fullname~"John Doe"`, request.query.Get("cql"))
+}
+
+// readMultipart returns values of fields of multipart form sent in request.
+func readMultipart(t *testing.T, request fixtureRequest) map[string]string {
+	_, params, err := mime.ParseMediaType(request.header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("unable to parse content type: %s", err)
+	}
+
+	reader := multipart.NewReader(
+		bytes.NewReader(request.body),
+		params["boundary"],
+	)
+
+	fields := map[string]string{}
+
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+
+		value, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatalf("unable to read form field: %s", err)
+		}
+
+		fields[part.FormName()] = string(value)
+	}
+
+	return fields
+}
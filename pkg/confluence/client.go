@@ -0,0 +1,121 @@
+package confluence
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+)
+
+const (
+	APIVersionAuto = `auto`
+	APIVersion1    = `v1`
+	APIVersion2    = `v2`
+)
+
+// Client describes operations which mark performs against Confluence.
+// It is implemented by API (REST API v1) and CloudAPI (REST API v2).
+type Client interface {
+	FindRootPage(space string) (*PageInfo, error)
+	FindPage(space string, title string) (*PageInfo, error)
+	GetPageByID(pageID string) (*PageInfo, error)
+	// GetPageBody returns content of given version of the page in storage
+	// format. Zero version means current version of the page.
+	GetPageBody(pageID string, version int64) (string, error)
+	CreatePage(
+		space string,
+		parent *PageInfo,
+		title string,
+		body string,
+	) (*PageInfo, error)
+	FindBlogPost(space string, title string, date string) (*PageInfo, error)
+	CreateBlogPost(space string, title string, body string) (*PageInfo, error)
+	// UpdatePage replaces content of the page and increments its version.
+	UpdatePage(page *PageInfo, newContent string) error
+	RestrictPageUpdates(page *PageInfo, allowedUser string) error
+	GetChildPages(pageID string) ([]PageInfo, error)
+	DeletePage(pageID string) error
+	ArchivePage(pageID string) error
+
+	GetPageLabels(pageID string) ([]Label, error)
+	AddPageLabels(pageID string, labels []string) error
+	RemovePageLabel(pageID string, label string) error
+	FindPagesByLabel(space string, label string) ([]PageInfo, error)
+
+	GetContentProperty(contentID string, key string) (*ContentProperty, error)
+	SetContentProperty(contentID string, key string, value interface{}) error
+	DeleteContentProperty(contentID string, key string) error
+
+	GetAttachments(pageID string) ([]AttachmentInfo, error)
+	CreateAttachment(
+		pageID string,
+		name string,
+		comment string,
+		path string,
+	) (AttachmentInfo, error)
+	UpdateAttachment(
+		pageID string,
+		attachID string,
+		name string,
+		comment string,
+		path string,
+	) (AttachmentInfo, error)
+	DeleteAttachment(attachID string) error
+	DownloadAttachment(attachment AttachmentInfo, writer io.Writer) error
+
+	GetUserByName(name string) (*User, error)
+}
+
+// NewClient returns Client which talks to Confluence using specified API
+// version. In case of APIVersionAuto REST API v2 is used for Confluence Cloud
+// instances and REST API v1 is used otherwise.
+func NewClient(
+	baseURL string,
+	username string,
+	password string,
+	version string,
+) (Client, error) {
+	if version == APIVersionAuto || version == "" {
+		uri, err := url.Parse(baseURL)
+		if err != nil {
+			return nil, err
+		}
+
+		if isCloud(uri.Host) {
+			version = APIVersion2
+		} else {
+			version = APIVersion1
+		}
+	}
+
+	switch version {
+	case APIVersion1:
+		return NewAPI(baseURL, username, password), nil
+
+	case APIVersion2:
+		api, err := NewCloudAPI(baseURL, username, password)
+		if err != nil {
+			return nil, err
+		}
+
+		return api, nil
+
+	default:
+		return nil, fmt.Errorf(
+			"unsupported Confluence API version: %q (expected %s, %s or %s)",
+			version,
+			APIVersionAuto,
+			APIVersion1,
+			APIVersion2,
+		)
+	}
+}
+
+func isCloud(host string) bool {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	return strings.HasSuffix(host, ".atlassian.net")
+}
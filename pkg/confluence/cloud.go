@@ -0,0 +1,759 @@
+package confluence
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/bndr/gopencils"
+	"github.com/reconquest/karma-go"
+)
+
+// CloudAPI implements Client using Confluence Cloud REST API v2.
+//
+// REST API v2 has no endpoints for uploading attachments, adding labels,
+// setting restrictions, searching content and users, so these operations are
+// delegated to REST API v1. Content properties are managed through REST API
+// v1 as well, because it uses the same endpoint for pages and attachments.
+type CloudAPI struct {
+	*API
+
+	v2 *gopencils.Resource
+
+	// context is a path prefix of Confluence instance (usually /wiki),
+	// REST API v2 returns attachment download links relative to it.
+	context string
+
+	spaces map[string]*spaceInfo
+
+	// blogposts holds IDs of known blog posts, because REST API v2 uses
+	// different endpoints for pages and blog posts.
+	blogposts map[string]bool
+}
+
+type spaceInfo struct {
+	ID         string `json:"id"`
+	Key        string `json:"key"`
+	HomepageID string `json:"homepageId"`
+}
+
+type pageInfoV2 struct {
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	ParentID  string `json:"parentId"`
+	CreatedAt string `json:"createdAt"`
+
+	Version struct {
+		Number int64 `json:"number"`
+	} `json:"version"`
+
+	Links struct {
+		Full string `json:"webui"`
+	} `json:"_links"`
+}
+
+type attachmentInfoV2 struct {
+	ID           string `json:"id"`
+	Title        string `json:"title"`
+	Comment      string `json:"comment"`
+	DownloadLink string `json:"downloadLink"`
+}
+
+// linksV2 holds link to the next page of results returned by REST API v2.
+type linksV2 struct {
+	Next string `json:"next"`
+}
+
+func NewCloudAPI(
+	baseURL string,
+	username string,
+	password string,
+) (*CloudAPI, error) {
+	auth := &gopencils.BasicAuth{Username: username, Password: password}
+
+	uri, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, karma.Format(
+			err,
+			"unable to parse Confluence base URL: %q",
+			baseURL,
+		)
+	}
+
+	return &CloudAPI{
+		API: NewAPI(baseURL, username, password),
+
+		v2: gopencils.Api(baseURL+"/api/v2", auth),
+
+		context:   strings.TrimRight(uri.Path, `/`),
+		spaces:    map[string]*spaceInfo{},
+		blogposts: map[string]bool{},
+	}, nil
+}
+
+// getNextCursor returns cursor which should be passed to obtain the next page
+// of results or empty string if there are no more results.
+func getNextCursor(links linksV2) (string, error) {
+	if links.Next == "" {
+		return "", nil
+	}
+
+	next, err := url.Parse(links.Next)
+	if err != nil {
+		return "", karma.Format(
+			err,
+			"unable to parse next page link: %q",
+			links.Next,
+		)
+	}
+
+	return next.Query().Get("cursor"), nil
+}
+
+func (api *CloudAPI) getSpace(key string) (*spaceInfo, error) {
+	if space, ok := api.spaces[key]; ok {
+		return space, nil
+	}
+
+	var result struct {
+		Results []spaceInfo `json:"results"`
+	}
+
+	request, err := api.v2.Res(
+		"spaces", &result,
+	).Get(map[string]string{"keys": key})
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Raw.StatusCode != 200 {
+		return nil, newErrorStatusNotOK(request)
+	}
+
+	if len(result.Results) == 0 {
+		return nil, errors.New("no such space")
+	}
+
+	api.spaces[key] = &result.Results[0]
+
+	return api.spaces[key], nil
+}
+
+func (api *CloudAPI) FindRootPage(space string) (*PageInfo, error) {
+	info, err := api.getSpace(space)
+	if err != nil {
+		return nil, karma.Format(
+			err,
+			"can't obtain space %q",
+			space,
+		)
+	}
+
+	if info.HomepageID == "" {
+		return nil, fmt.Errorf("space %q has no homepage", space)
+	}
+
+	return api.GetPageByID(info.HomepageID)
+}
+
+// FindPagesByLabel finds pages and blog posts using REST API v1, because
+// REST API v2 has no search. Found blog posts are remembered, so they are
+// requested using blog post endpoints afterwards.
+func (api *CloudAPI) FindPagesByLabel(
+	space string,
+	label string,
+) ([]PageInfo, error) {
+	pages, err := api.API.FindPagesByLabel(space, label)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, page := range pages {
+		if page.Type == ContentTypeBlogPost {
+			api.blogposts[page.ID] = true
+		}
+	}
+
+	return pages, nil
+}
+
+func (api *CloudAPI) FindPage(space string, title string) (*PageInfo, error) {
+	if title == "" {
+		return api.FindRootPage(space)
+	}
+
+	info, err := api.getSpace(space)
+	if err != nil {
+		return nil, karma.Format(
+			err,
+			"can't obtain space %q",
+			space,
+		)
+	}
+
+	var result struct {
+		Results []pageInfoV2 `json:"results"`
+	}
+
+	request, err := api.v2.Res(
+		"pages", &result,
+	).Get(map[string]string{
+		"space-id": info.ID,
+		"title":    title,
+		"status":   "current",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Raw.StatusCode != 200 {
+		return nil, newErrorStatusNotOK(request)
+	}
+
+	if len(result.Results) == 0 {
+		return nil, nil
+	}
+
+	return api.getPageInfo(result.Results[0])
+}
+
+func (api *CloudAPI) GetPageByID(pageID string) (*PageInfo, error) {
+	var page pageInfoV2
+
+	request, err := api.v2.Res(
+		"pages/"+pageID, &page,
+	).Get()
+	if err != nil {
+		return nil, err
+	}
+
+	// given ID may belong to blog post
+	if request.Raw.StatusCode == 404 {
+		request, err = api.v2.Res(
+			"blogposts/"+pageID, &page,
+		).Get()
+		if err != nil {
+			return nil, err
+		}
+
+		if request.Raw.StatusCode != 200 {
+			return nil, newErrorStatusNotOK(request)
+		}
+
+		return api.getBlogPostInfo(page), nil
+	}
+
+	if request.Raw.StatusCode != 200 {
+		return nil, newErrorStatusNotOK(request)
+	}
+
+	return api.getPageInfo(page)
+}
+
+func (api *CloudAPI) GetPageBody(
+	pageID string,
+	version int64,
+) (string, error) {
+	var page struct {
+		Body struct {
+			Storage struct {
+				Value string `json:"value"`
+			} `json:"storage"`
+		} `json:"body"`
+	}
+
+	payload := map[string]string{"body-format": "storage"}
+	if version != 0 {
+		payload["version"] = fmt.Sprint(version)
+	}
+
+	request, err := api.v2.Res(
+		api.getContentPath(pageID), &page,
+	).Get(payload)
+	if err != nil {
+		return "", err
+	}
+
+	if request.Raw.StatusCode != 200 {
+		return "", newErrorStatusNotOK(request)
+	}
+
+	return page.Body.Storage.Value, nil
+}
+
+func (api *CloudAPI) FindBlogPost(
+	space string,
+	title string,
+	date string,
+) (*PageInfo, error) {
+	info, err := api.getSpace(space)
+	if err != nil {
+		return nil, karma.Format(
+			err,
+			"can't obtain space %q",
+			space,
+		)
+	}
+
+	var result struct {
+		Results []pageInfoV2 `json:"results"`
+	}
+
+	request, err := api.v2.Res(
+		"blogposts", &result,
+	).Get(map[string]string{
+		"space-id": info.ID,
+		"title":    title,
+		"status":   "current",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Raw.StatusCode != 200 {
+		return nil, newErrorStatusNotOK(request)
+	}
+
+	// REST API v2 can't filter blog posts by posting day
+	for _, post := range result.Results {
+		if date == "" || strings.HasPrefix(post.CreatedAt, date) {
+			return api.getBlogPostInfo(post), nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (api *CloudAPI) getBlogPostInfo(post pageInfoV2) *PageInfo {
+	info := &PageInfo{
+		ID:    post.ID,
+		Type:  ContentTypeBlogPost,
+		Title: post.Title,
+	}
+
+	info.Version.Number = post.Version.Number
+	info.Links.Full = post.Links.Full
+
+	api.blogposts[post.ID] = true
+
+	return info
+}
+
+// getContentPath returns REST API v2 path of page or blog post with given ID.
+func (api *CloudAPI) getContentPath(contentID string) string {
+	if api.blogposts[contentID] {
+		return "blogposts/" + contentID
+	}
+
+	return "pages/" + contentID
+}
+
+// getPageInfo converts REST API v2 page into PageInfo, REST API v2 doesn't
+// return ancestors along with page, so they are requested separately.
+func (api *CloudAPI) getPageInfo(page pageInfoV2) (*PageInfo, error) {
+	info := &PageInfo{
+		ID:    page.ID,
+		Type:  ContentTypePage,
+		Title: page.Title,
+	}
+
+	info.Version.Number = page.Version.Number
+	info.Links.Full = page.Links.Full
+
+	ancestors, err := api.getAncestors(page.ID)
+	if err != nil {
+		return nil, karma.Format(
+			err,
+			"unable to obtain ancestors of page %q",
+			page.Title,
+		)
+	}
+
+	info.Ancestors = ancestors
+
+	return info, nil
+}
+
+func (api *CloudAPI) getAncestors(pageID string) ([]PageAncestor, error) {
+	var ancestors struct {
+		Results []struct {
+			ID string `json:"id"`
+		} `json:"results"`
+	}
+
+	request, err := api.v2.Res(
+		"pages/"+pageID+"/ancestors", &ancestors,
+	).Get(map[string]string{"limit": "250"})
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Raw.StatusCode != 200 {
+		return nil, newErrorStatusNotOK(request)
+	}
+
+	if len(ancestors.Results) == 0 {
+		return nil, nil
+	}
+
+	ids := []string{}
+	for _, ancestor := range ancestors.Results {
+		ids = append(ids, ancestor.ID)
+	}
+
+	var pages struct {
+		Results []pageInfoV2 `json:"results"`
+	}
+
+	request, err = api.v2.Res(
+		"pages", &pages,
+	).Get(map[string]string{
+		"id":    strings.Join(ids, ","),
+		"limit": "250",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Raw.StatusCode != 200 {
+		return nil, newErrorStatusNotOK(request)
+	}
+
+	titles := map[string]string{}
+	for _, page := range pages.Results {
+		titles[page.ID] = page.Title
+	}
+
+	// ancestors are returned in top-to-bottom order, which is the same
+	// order as REST API v1 uses
+	result := []PageAncestor{}
+	for _, id := range ids {
+		result = append(result, PageAncestor{
+			Id:    id,
+			Title: titles[id],
+		})
+	}
+
+	return result, nil
+}
+
+func (api *CloudAPI) CreatePage(
+	space string,
+	parent *PageInfo,
+	title string,
+	body string,
+) (*PageInfo, error) {
+	return api.createContent(ContentTypePage, space, parent, title, body)
+}
+
+func (api *CloudAPI) CreateBlogPost(
+	space string,
+	title string,
+	body string,
+) (*PageInfo, error) {
+	return api.createContent(ContentTypeBlogPost, space, nil, title, body)
+}
+
+func (api *CloudAPI) createContent(
+	contentType string,
+	space string,
+	parent *PageInfo,
+	title string,
+	body string,
+) (*PageInfo, error) {
+	info, err := api.getSpace(space)
+	if err != nil {
+		return nil, karma.Format(
+			err,
+			"can't obtain space %q",
+			space,
+		)
+	}
+
+	payload := map[string]interface{}{
+		"spaceId": info.ID,
+		"status":  "current",
+		"title":   title,
+		"body": map[string]interface{}{
+			"representation": "storage",
+			"value":          body,
+		},
+	}
+
+	if parent != nil {
+		payload["parentId"] = parent.ID
+	}
+
+	resource := "pages"
+	if contentType == ContentTypeBlogPost {
+		resource = "blogposts"
+	}
+
+	var page pageInfoV2
+
+	request, err := api.v2.Res(
+		resource, &page,
+	).Post(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Raw.StatusCode != 200 {
+		return nil, newErrorStatusNotOK(request)
+	}
+
+	if contentType == ContentTypeBlogPost {
+		api.blogposts[page.ID] = true
+	}
+
+	// REST API v2 has no page metadata in payload, so editor version is
+	// set using page property
+	request, err = api.v2.Res(
+		api.getContentPath(page.ID)+"/properties", &map[string]interface{}{},
+	).Post(map[string]interface{}{
+		"key":   "editor",
+		"value": "v2",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Raw.StatusCode != 200 {
+		return nil, newErrorStatusNotOK(request)
+	}
+
+	result := &PageInfo{
+		ID:    page.ID,
+		Type:  contentType,
+		Title: page.Title,
+	}
+
+	result.Version.Number = page.Version.Number
+	result.Links.Full = page.Links.Full
+
+	if parent != nil {
+		result.Ancestors = append(result.Ancestors, parent.Ancestors...)
+		result.Ancestors = append(result.Ancestors, PageAncestor{
+			Id:    parent.ID,
+			Title: parent.Title,
+		})
+	}
+
+	return result, nil
+}
+
+func (api *CloudAPI) UpdatePage(
+	page *PageInfo, newContent string,
+) error {
+	nextPageVersion := page.Version.Number + 1
+
+	payload := map[string]interface{}{
+		"id":     page.ID,
+		"status": "current",
+		"title":  page.Title,
+		"version": map[string]interface{}{
+			"number":    nextPageVersion,
+			"minorEdit": false,
+		},
+		"body": map[string]interface{}{
+			"representation": "storage",
+			"value":          newContent,
+		},
+	}
+
+	resource := "blogposts/" + page.ID
+
+	// blog posts are not part of page tree, and home page of the space has
+	// no parents, so it's kept in place
+	if page.Type != ContentTypeBlogPost {
+		if len(page.Ancestors) > 0 {
+			payload["parentId"] = page.Ancestors[len(page.Ancestors)-1].Id
+		}
+
+		resource = "pages/" + page.ID
+	}
+
+	request, err := api.v2.Res(
+		resource, &map[string]interface{}{},
+	).Put(payload)
+	if err != nil {
+		return err
+	}
+
+	if request.Raw.StatusCode != 200 {
+		return newErrorStatusNotOK(request)
+	}
+
+	page.Version.Number = nextPageVersion
+
+	return nil
+}
+
+func (api *CloudAPI) GetChildPages(pageID string) ([]PageInfo, error) {
+	pages := []PageInfo{}
+
+	query := map[string]string{"limit": "250"}
+
+	for {
+		var result struct {
+			Results []pageInfoV2 `json:"results"`
+			Links   linksV2      `json:"_links"`
+		}
+
+		request, err := api.v2.Res(
+			"pages/"+pageID+"/children", &result,
+		).Get(query)
+		if err != nil {
+			return nil, err
+		}
+
+		if request.Raw.StatusCode != 200 {
+			return nil, newErrorStatusNotOK(request)
+		}
+
+		for _, page := range result.Results {
+			info := PageInfo{
+				ID:    page.ID,
+				Title: page.Title,
+			}
+
+			info.Version.Number = page.Version.Number
+			info.Links.Full = page.Links.Full
+
+			pages = append(pages, info)
+		}
+
+		cursor, err := getNextCursor(result.Links)
+		if err != nil {
+			return nil, err
+		}
+
+		if cursor == "" {
+			break
+		}
+
+		query["cursor"] = cursor
+	}
+
+	return pages, nil
+}
+
+func (api *CloudAPI) DeletePage(pageID string) error {
+	request, err := deleteResource(
+		api.v2.Res(api.getContentPath(pageID), &map[string]interface{}{}),
+	)
+	if err != nil {
+		return err
+	}
+
+	if request.Raw.StatusCode != 204 {
+		return newErrorStatusNotOK(request)
+	}
+
+	return nil
+}
+
+func (api *CloudAPI) GetPageLabels(pageID string) ([]Label, error) {
+	labels := []Label{}
+
+	query := map[string]string{"limit": "250"}
+
+	for {
+		var result struct {
+			Results []Label `json:"results"`
+			Links   linksV2 `json:"_links"`
+		}
+
+		request, err := api.v2.Res(
+			api.getContentPath(pageID)+"/labels", &result,
+		).Get(query)
+		if err != nil {
+			return nil, err
+		}
+
+		if request.Raw.StatusCode != 200 {
+			return nil, newErrorStatusNotOK(request)
+		}
+
+		labels = append(labels, result.Results...)
+
+		cursor, err := getNextCursor(result.Links)
+		if err != nil {
+			return nil, err
+		}
+
+		if cursor == "" {
+			break
+		}
+
+		query["cursor"] = cursor
+	}
+
+	return labels, nil
+}
+
+func (api *CloudAPI) GetAttachments(pageID string) ([]AttachmentInfo, error) {
+	attachments := []AttachmentInfo{}
+
+	query := map[string]string{"limit": "250"}
+
+	for {
+		var result struct {
+			Results []attachmentInfoV2 `json:"results"`
+			Links   linksV2            `json:"_links"`
+		}
+
+		request, err := api.v2.Res(
+			api.getContentPath(pageID)+"/attachments", &result,
+		).Get(query)
+		if err != nil {
+			return nil, err
+		}
+
+		if request.Raw.StatusCode != 200 {
+			return nil, newErrorStatusNotOK(request)
+		}
+
+		for _, attachment := range result.Results {
+			var info AttachmentInfo
+
+			info.ID = attachment.ID
+			info.Filename = attachment.Title
+			info.Metadata.Comment = attachment.Comment
+			info.Links.Context = api.context
+			info.Links.Download = attachment.DownloadLink
+
+			attachments = append(attachments, info)
+		}
+
+		cursor, err := getNextCursor(result.Links)
+		if err != nil {
+			return nil, err
+		}
+
+		if cursor == "" {
+			break
+		}
+
+		query["cursor"] = cursor
+	}
+
+	return attachments, nil
+}
+
+// DeleteAttachment moves attachment to trash.
+func (api *CloudAPI) DeleteAttachment(attachID string) error {
+	request, err := deleteResource(
+		api.v2.Res("attachments/"+attachID, &map[string]interface{}{}),
+	)
+	if err != nil {
+		return err
+	}
+
+	if request.Raw.StatusCode != 204 {
+		return newErrorStatusNotOK(request)
+	}
+
+	return nil
+}
@@ -0,0 +1,611 @@
+package confluence
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func newTestCloudAPI(t *testing.T) (*CloudAPI, *fixtureServer) {
+	server := newFixtureServer(t)
+
+	api, err := NewCloudAPI(server.URL+"/wiki", "jdoe", "secret")
+	assertNoError(t, err)
+
+	server.on(
+		"GET", "/wiki/api/v2/spaces", query{"keys": "DOC"},
+		200, "v2/spaces.json",
+	)
+
+	return api, server
+}
+
+// onPage registers routes which are used to obtain page 123 with ancestors.
+func onPage(server *fixtureServer) {
+	server.on("GET", "/wiki/api/v2/pages/123", nil, 200, "v2/page.json")
+	server.on(
+		"GET", "/wiki/api/v2/pages/123/ancestors", nil,
+		200, "v2/ancestors.json",
+	)
+	server.on(
+		"GET", "/wiki/api/v2/pages", query{"id": "100,110"},
+		200, "v2/pages-ancestors.json",
+	)
+}
+
+func TestNewCloudAPI_InvalidURL(t *testing.T) {
+	_, err := NewCloudAPI("http://[::1", "jdoe", "secret")
+	if err == nil {
+		t.Fatalf("expected error for invalid base URL")
+	}
+
+	_, err = NewClient("http://[::1", "jdoe", "secret", APIVersion2)
+	if err == nil {
+		t.Fatalf("expected error for invalid base URL")
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	client, err := NewClient(
+		"https://example.atlassian.net/wiki", "jdoe", "secret", APIVersionAuto,
+	)
+	assertNoError(t, err)
+
+	if _, ok := client.(*CloudAPI); !ok {
+		t.Fatalf("expected REST API v2 client for cloud, got %T", client)
+	}
+
+	client, err = NewClient(
+		"https://confluence.example.com", "jdoe", "secret", APIVersionAuto,
+	)
+	assertNoError(t, err)
+
+	if _, ok := client.(*API); !ok {
+		t.Fatalf("expected REST API v1 client for server, got %T", client)
+	}
+
+	// only subdomains of atlassian.net are cloud instances
+	client, err = NewClient(
+		"https://evilatlassian.net", "jdoe", "secret", APIVersionAuto,
+	)
+	assertNoError(t, err)
+
+	if _, ok := client.(*API); !ok {
+		t.Fatalf("expected REST API v1 client for server, got %T", client)
+	}
+
+	_, err = NewClient("https://confluence.example.com", "", "", "v3")
+	if err == nil {
+		t.Fatalf("expected error for unsupported API version")
+	}
+}
+
+func TestCloudAPI_FindRootPage(t *testing.T) {
+	api, server := newTestCloudAPI(t)
+
+	server.on("GET", "/wiki/api/v2/pages/100", nil, 200, "v2/page-home.json")
+	server.on(
+		"GET", "/wiki/api/v2/pages/100/ancestors", nil,
+		200, "v2/ancestors-empty.json",
+	)
+
+	page, err := api.FindRootPage("DOC")
+	assertNoError(t, err)
+
+	assertEqual(t, "100", page.ID)
+	assertEqual(t, "Home", page.Title)
+	assertEqual(t, 0, len(page.Ancestors))
+
+	// space is requested only once
+	_, err = api.FindRootPage("DOC")
+	assertNoError(t, err)
+	assertEqual(t, 1, server.count("GET", "/wiki/api/v2/spaces"))
+
+	server.on(
+		"GET", "/wiki/api/v2/spaces", query{"keys": "NOPE"},
+		200, "v2/spaces-empty.json",
+	)
+
+	_, err = api.FindRootPage("NOPE")
+	if err == nil {
+		t.Fatalf("expected error for missing space")
+	}
+}
+
+func TestCloudAPI_FindPage(t *testing.T) {
+	api, server := newTestCloudAPI(t)
+
+	onPage(server)
+	server.on(
+		"GET", "/wiki/api/v2/pages", query{"title": "Guide"},
+		200, "v2/pages-guide.json",
+	)
+	server.on(
+		"GET", "/wiki/api/v2/pages", query{"title": "Missing"},
+		200, "v2/pages-empty.json",
+	)
+
+	page, err := api.FindPage("DOC", "Guide")
+	assertNoError(t, err)
+
+	assertEqual(t, "123", page.ID)
+	assertEqual(t, ContentTypePage, page.Type)
+	assertEqual(t, int64(7), page.Version.Number)
+	assertEqual(t, "/spaces/DOC/pages/123/Guide", page.Links.Full)
+	assertEqual(t, []PageAncestor{
+		{Id: "100", Title: "Home"},
+		{Id: "110", Title: "Docs"},
+	}, page.Ancestors)
+
+	page, err = api.FindPage("DOC", "Missing")
+	assertNoError(t, err)
+
+	if page != nil {
+		t.Fatalf("expected no page, got %#v", page)
+	}
+
+	request := server.request("GET", "/wiki/api/v2/pages")
+	assertEqual(t, "65537", request.query.Get("space-id"))
+	assertEqual(t, "current", request.query.Get("status"))
+}
+
+func TestCloudAPI_GetPageByID(t *testing.T) {
+	api, server := newTestCloudAPI(t)
+
+	onPage(server)
+	server.on("GET", "/wiki/api/v2/pages/456", nil, 404, "")
+	server.on(
+		"GET", "/wiki/api/v2/blogposts/456", nil, 200, "v2/blogpost.json",
+	)
+
+	page, err := api.GetPageByID("123")
+	assertNoError(t, err)
+
+	assertEqual(t, "Guide", page.Title)
+	assertEqual(t, 2, len(page.Ancestors))
+
+	post, err := api.GetPageByID("456")
+	assertNoError(t, err)
+
+	assertEqual(t, ContentTypeBlogPost, post.Type)
+	assertEqual(t, "Release Notes", post.Title)
+	assertEqual(t, int64(2), post.Version.Number)
+}
+
+func TestCloudAPI_GetPageBody(t *testing.T) {
+	api, server := newTestCloudAPI(t)
+
+	server.on(
+		"GET", "/wiki/api/v2/pages/123", query{"body-format": "storage"},
+		200, "v2/page-body.json",
+	)
+
+	body, err := api.GetPageBody("123", 0)
+	assertNoError(t, err)
+	assertEqual(t, "<p>current body</p>", body)
+
+	_, err = api.GetPageBody("123", 3)
+	assertNoError(t, err)
+
+	request := server.request("GET", "/wiki/api/v2/pages/123")
+	assertEqual(t, "3", request.query.Get("version"))
+}
+
+func TestCloudAPI_CreatePage(t *testing.T) {
+	api, server := newTestCloudAPI(t)
+
+	server.on("POST", "/wiki/api/v2/pages", nil, 200, "v2/page-created.json")
+	server.on(
+		"POST", "/wiki/api/v2/pages/124/properties", nil,
+		200, "v2/property-editor.json",
+	)
+
+	parent := &PageInfo{ID: "100", Title: "Home"}
+
+	page, err := api.CreatePage("DOC", parent, "New Page", "<p>new</p>")
+	assertNoError(t, err)
+
+	assertEqual(t, "124", page.ID)
+	assertEqual(t, ContentTypePage, page.Type)
+	assertEqual(t, []PageAncestor{{Id: "100", Title: "Home"}}, page.Ancestors)
+
+	payload := decodePayload(t, server.request("POST", "/wiki/api/v2/pages"))
+	assertEqual(t, "65537", lookup(payload, "spaceId"))
+	assertEqual(t, "100", lookup(payload, "parentId"))
+	assertEqual(t, "New Page", lookup(payload, "title"))
+	assertEqual(t, "storage", lookup(payload, "body", "representation"))
+	assertEqual(t, "<p>new</p>", lookup(payload, "body", "value"))
+
+	payload = decodePayload(
+		t, server.request("POST", "/wiki/api/v2/pages/124/properties"),
+	)
+	assertEqual(t, "editor", lookup(payload, "key"))
+	assertEqual(t, "v2", lookup(payload, "value"))
+}
+
+func TestCloudAPI_FindBlogPost(t *testing.T) {
+	api, server := newTestCloudAPI(t)
+
+	server.on(
+		"GET", "/wiki/api/v2/blogposts", query{"title": "Release Notes"},
+		200, "v2/blogposts.json",
+	)
+
+	post, err := api.FindBlogPost("DOC", "Release Notes", "2020-03-01")
+	assertNoError(t, err)
+
+	assertEqual(t, "456", post.ID)
+	assertEqual(t, ContentTypeBlogPost, post.Type)
+
+	post, err = api.FindBlogPost("DOC", "Release Notes", "")
+	assertNoError(t, err)
+	assertEqual(t, "455", post.ID)
+
+	post, err = api.FindBlogPost("DOC", "Release Notes", "2020-04-01")
+	assertNoError(t, err)
+
+	if post != nil {
+		t.Fatalf("expected no blog post, got %#v", post)
+	}
+}
+
+func TestCloudAPI_CreateBlogPost(t *testing.T) {
+	api, server := newTestCloudAPI(t)
+
+	server.on(
+		"POST", "/wiki/api/v2/blogposts", nil, 200, "v2/blogpost-created.json",
+	)
+	server.on(
+		"POST", "/wiki/api/v2/blogposts/457/properties", nil,
+		200, "v2/property-editor.json",
+	)
+
+	post, err := api.CreateBlogPost("DOC", "Announcement", "<p>news</p>")
+	assertNoError(t, err)
+
+	assertEqual(t, "457", post.ID)
+	assertEqual(t, ContentTypeBlogPost, post.Type)
+
+	payload := decodePayload(
+		t, server.request("POST", "/wiki/api/v2/blogposts"),
+	)
+	assertEqual(t, nil, lookup(payload, "parentId"))
+
+	// blog post content is addressed by blog post endpoints afterwards
+	assertEqual(t, "blogposts/457", api.getContentPath("457"))
+}
+
+func TestCloudAPI_UpdatePage(t *testing.T) {
+	api, server := newTestCloudAPI(t)
+
+	server.on("PUT", "/wiki/api/v2/pages/123", nil, 200, "v2/page-updated.json")
+	server.on("PUT", "/wiki/api/v2/blogposts/456", nil, 200, "v2/blogpost.json")
+
+	page := &PageInfo{
+		ID:    "123",
+		Type:  ContentTypePage,
+		Title: "Guide",
+		Ancestors: []PageAncestor{
+			{Id: "100", Title: "Home"},
+			{Id: "110", Title: "Docs"},
+		},
+	}
+	page.Version.Number = 7
+
+	err := api.UpdatePage(page, "<p>updated</p>")
+	assertNoError(t, err)
+
+	assertEqual(t, int64(8), page.Version.Number)
+
+	payload := decodePayload(t, server.request("PUT", "/wiki/api/v2/pages/123"))
+	assertEqual(t, "110", lookup(payload, "parentId"))
+	assertEqual(t, float64(8), lookup(payload, "version", "number"))
+	assertEqual(t, "<p>updated</p>", lookup(payload, "body", "value"))
+
+	post := &PageInfo{ID: "456", Type: ContentTypeBlogPost, Title: "Notes"}
+	post.Version.Number = 2
+
+	err = api.UpdatePage(post, "<p>notes</p>")
+	assertNoError(t, err)
+
+	payload = decodePayload(
+		t, server.request("PUT", "/wiki/api/v2/blogposts/456"),
+	)
+	assertEqual(t, nil, lookup(payload, "parentId"))
+	assertEqual(t, float64(3), lookup(payload, "version", "number"))
+
+	// home page of the space has no parents and is kept in place
+	home := &PageInfo{ID: "123", Type: ContentTypePage, Title: "Home"}
+	home.Version.Number = 8
+
+	assertNoError(t, api.UpdatePage(home, "<p>home</p>"))
+
+	payload = decodePayload(t, server.request("PUT", "/wiki/api/v2/pages/123"))
+	assertEqual(t, "Home", lookup(payload, "title"))
+	assertEqual(t, nil, lookup(payload, "parentId"))
+}
+
+func TestCloudAPI_RestrictPageUpdates(t *testing.T) {
+	api, server := newTestCloudAPI(t)
+
+	server.on(
+		"POST", "/wiki/rpc/json-rpc/confluenceservice-v2/setContentPermissions",
+		nil, 200, "v1/json-rpc-true.json",
+	)
+
+	err := api.RestrictPageUpdates(&PageInfo{ID: "123"}, "jdoe")
+	assertNoError(t, err)
+}
+
+func TestCloudAPI_GetChildPages(t *testing.T) {
+	api, server := newTestCloudAPI(t)
+
+	server.on(
+		"GET", "/wiki/api/v2/pages/110/children",
+		query{"cursor": "eyJpZCI6IjEyMyJ9"},
+		200, "v2/children-2.json",
+	)
+	server.on(
+		"GET", "/wiki/api/v2/pages/110/children", nil,
+		200, "v2/children-1.json",
+	)
+
+	pages, err := api.GetChildPages("110")
+	assertNoError(t, err)
+
+	assertEqual(t, 2, len(pages))
+	assertEqual(t, "Guide", pages[0].Title)
+	assertEqual(t, "FAQ", pages[1].Title)
+	assertEqual(t, 2, server.count("GET", "/wiki/api/v2/pages/110/children"))
+}
+
+func TestCloudAPI_DeletePage(t *testing.T) {
+	api, server := newTestCloudAPI(t)
+
+	server.on("DELETE", "/wiki/api/v2/pages/123", nil, 204, "")
+
+	assertNoError(t, api.DeletePage("123"))
+	assertEqual(t, 1, server.count("DELETE", "/wiki/api/v2/pages/123"))
+}
+
+func TestCloudAPI_ArchivePage(t *testing.T) {
+	api, server := newTestCloudAPI(t)
+
+	server.on(
+		"POST", "/wiki/rest/api/content/archive", nil, 202, "v1/archive.json",
+	)
+
+	assertNoError(t, api.ArchivePage("123"))
+}
+
+func TestCloudAPI_GetPageLabels(t *testing.T) {
+	api, server := newTestCloudAPI(t)
+
+	server.on(
+		"GET", "/wiki/api/v2/pages/123/labels",
+		query{"cursor": "eyJpZCI6IjkwMDEifQ"},
+		200, "v2/labels-2.json",
+	)
+	server.on(
+		"GET", "/wiki/api/v2/pages/123/labels", nil, 200, "v2/labels-1.json",
+	)
+
+	labels, err := api.GetPageLabels("123")
+	assertNoError(t, err)
+
+	assertEqual(t, []Label{
+		{ID: "9001", Prefix: "global", Name: "docs"},
+		{ID: "9002", Prefix: "global", Name: "mark-root"},
+	}, labels)
+}
+
+func TestCloudAPI_AddPageLabels(t *testing.T) {
+	api, server := newTestCloudAPI(t)
+
+	server.on(
+		"POST", "/wiki/rest/api/content/123/label", nil,
+		200, "v1/labels-added.json",
+	)
+
+	assertNoError(t, api.AddPageLabels("123", []string{"docs", "howto"}))
+}
+
+func TestCloudAPI_RemovePageLabel(t *testing.T) {
+	api, server := newTestCloudAPI(t)
+
+	server.on(
+		"DELETE", "/wiki/rest/api/content/123/label", query{"name": "docs"},
+		204, "",
+	)
+
+	assertNoError(t, api.RemovePageLabel("123", "docs"))
+	assertEqual(
+		t, 1, server.count("DELETE", "/wiki/rest/api/content/123/label"),
+	)
+}
+
+func TestCloudAPI_FindPagesByLabel(t *testing.T) {
+	api, server := newTestCloudAPI(t)
+
+	server.on(
+		"GET", "/wiki/rest/api/content/search", nil,
+		200, "v1/search-label.json",
+	)
+
+	pages, err := api.FindPagesByLabel("DOC", "mark-root")
+	assertNoError(t, err)
+
+	assertEqual(t, 1, len(pages))
+}
+
+func TestCloudAPI_FindPagesByLabel_BlogPost(t *testing.T) {
+	api, server := newTestCloudAPI(t)
+
+	server.on(
+		"GET", "/wiki/rest/api/content/search", nil,
+		200, "v1/search-label-blogpost.json",
+	)
+	server.on("DELETE", "/wiki/api/v2/blogposts/130", nil, 204, "")
+
+	pages, err := api.FindPagesByLabel("DOC", "docs")
+	assertNoError(t, err)
+	assertEqual(t, 1, len(pages))
+
+	// found blog post is removed using blog post endpoint
+	assertNoError(t, api.DeletePage("130"))
+	assertEqual(t, 1, server.count("DELETE", "/wiki/api/v2/blogposts/130"))
+}
+
+func TestCloudAPI_ContentProperty(t *testing.T) {
+	api, server := newTestCloudAPI(t)
+
+	server.on(
+		"GET", "/wiki/rest/api/content/123/property/mark-source-path", nil,
+		200, "v1/property.json",
+	)
+	server.on(
+		"PUT", "/wiki/rest/api/content/123/property/mark-source-path", nil,
+		200, "v1/property.json",
+	)
+
+	property, err := api.GetContentProperty("123", "mark-source-path")
+	assertNoError(t, err)
+	assertEqual(t, "docs/guide.md", property.Value)
+
+	err = api.SetContentProperty("123", "mark-source-path", "docs/moved.md")
+	assertNoError(t, err)
+	assertEqual(
+		t, 1,
+		server.count(
+			"PUT", "/wiki/rest/api/content/123/property/mark-source-path",
+		),
+	)
+}
+
+func TestCloudAPI_DeleteContentProperty(t *testing.T) {
+	api, server := newTestCloudAPI(t)
+
+	server.on(
+		"DELETE", "/wiki/rest/api/content/123/property/emoji-title-published",
+		nil, 204, "",
+	)
+
+	assertNoError(t, api.DeleteContentProperty("123", "emoji-title-published"))
+	assertEqual(
+		t, 1,
+		server.count(
+			"DELETE",
+			"/wiki/rest/api/content/123/property/emoji-title-published",
+		),
+	)
+}
+
+func TestCloudAPI_GetAttachments(t *testing.T) {
+	api, server := newTestCloudAPI(t)
+
+	server.on(
+		"GET", "/wiki/api/v2/pages/123/attachments",
+		query{"cursor": "eyJpZCI6ImF0dDEifQ"},
+		200, "v2/attachments-2.json",
+	)
+	server.on(
+		"GET", "/wiki/api/v2/pages/123/attachments", nil,
+		200, "v2/attachments-1.json",
+	)
+
+	attachments, err := api.GetAttachments("123")
+	assertNoError(t, err)
+
+	assertEqual(t, 2, len(attachments))
+	assertEqual(t, "att1", attachments[0].ID)
+	assertEqual(t, "img.png", attachments[0].Filename)
+	assertEqual(t, "uploaded by mark", attachments[0].Metadata.Comment)
+	assertEqual(t, "/wiki", attachments[0].Links.Context)
+	assertEqual(
+		t,
+		"/download/attachments/123/img.png?version=1&modificationDate=1584181267000&api=v2",
+		attachments[0].Links.Download,
+	)
+	assertEqual(t, "notes.txt", attachments[1].Filename)
+}
+
+func TestCloudAPI_CreateAttachment(t *testing.T) {
+	api, server := newTestCloudAPI(t)
+
+	server.on(
+		"POST", "/wiki/rest/api/content/123/child/attachment", nil,
+		200, "v1/attachment-created.json",
+	)
+
+	attachment, err := api.CreateAttachment(
+		"123", "diagram.svg", "abc123", filepath.Join("testdata", "v1", "img.png"),
+	)
+	assertNoError(t, err)
+
+	assertEqual(t, "att2", attachment.ID)
+
+	request := server.request(
+		"POST", "/wiki/rest/api/content/123/child/attachment",
+	)
+	assertEqual(t, "no-check", request.header.Get("X-Atlassian-Token"))
+}
+
+func TestCloudAPI_UpdateAttachment(t *testing.T) {
+	api, server := newTestCloudAPI(t)
+
+	server.on(
+		"POST", "/wiki/rest/api/content/123/child/attachment/att1/data", nil,
+		200, "v1/attachment-updated.json",
+	)
+
+	attachment, err := api.UpdateAttachment(
+		"123", "att1", "img.png", "def456",
+		filepath.Join("testdata", "v1", "img.png"),
+	)
+	assertNoError(t, err)
+
+	assertEqual(t, "def456", attachment.Metadata.Comment)
+}
+
+func TestCloudAPI_DeleteAttachment(t *testing.T) {
+	api, server := newTestCloudAPI(t)
+
+	server.on("DELETE", "/wiki/api/v2/attachments/att1", nil, 204, "")
+
+	assertNoError(t, api.DeleteAttachment("att1"))
+	assertEqual(t, 1, server.count("DELETE", "/wiki/api/v2/attachments/att1"))
+}
+
+func TestCloudAPI_DownloadAttachment(t *testing.T) {
+	api, server := newTestCloudAPI(t)
+
+	server.on(
+		"GET", "/wiki/api/v2/pages/123/attachments", nil,
+		200, "v2/attachments-2.json",
+	)
+	server.on(
+		"GET", "/wiki/download/attachments/123/notes.txt", nil,
+		200, "v1/img.png",
+	)
+
+	attachments, err := api.GetAttachments("123")
+	assertNoError(t, err)
+
+	var buffer bytes.Buffer
+
+	err = api.DownloadAttachment(attachments[0], &buffer)
+	assertNoError(t, err)
+
+	assertEqual(t, "png", buffer.String())
+}
+
+func TestCloudAPI_GetUserByName(t *testing.T) {
+	api, server := newTestCloudAPI(t)
+
+	server.on("GET", "/wiki/rest/api/search/user", nil, 200, "v1/user.json")
+
+	user, err := api.GetUserByName("John Doe")
+	assertNoError(t, err)
+
+	assertEqual(t, "5b10ac8d82e05b22cc7d4ef5", user.AccountID)
+}
@@ -0,0 +1,832 @@
+// Package fake provides in-memory implementation of confluence.Client, which
+// can be used to run mark publishing logic without live Confluence instance.
+package fake
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kovetskiy/mark/pkg/confluence"
+)
+
+type Page struct {
+	ID       string
+	Type     string
+	Space    string
+	Title    string
+	ParentID string
+	Version  int64
+	Body     string
+	Labels   []string
+
+	// History holds bodies of previous versions of the page, body of version
+	// N is stored at index N-1.
+	History []string
+
+	// Created is a posting day of blog post in YYYY-MM-DD format.
+	Created string
+
+	// Status is one of "current", "trashed" or "archived", only current
+	// pages are visible through confluence.Client methods.
+	Status string
+
+	// RestrictedTo is a name of user which is only allowed to edit page.
+	RestrictedTo string
+
+	Attachments []*Attachment
+}
+
+type Attachment struct {
+	ID       string
+	Filename string
+	Comment  string
+	Version  int64
+	Data     []byte
+}
+
+// Confluence is in-memory Confluence instance which satisfies
+// confluence.Client interface. It is safe for concurrent use.
+type Confluence struct {
+	mutex sync.Mutex
+
+	sequence int64
+
+	spaces     map[string]string
+	pages      map[string]*Page
+	users      map[string]confluence.User
+	properties map[string]map[string]*confluence.ContentProperty
+}
+
+const (
+	statusCurrent  = `current`
+	statusTrashed  = `trashed`
+	statusArchived = `archived`
+)
+
+var _ confluence.Client = (*Confluence)(nil)
+
+func New() *Confluence {
+	return &Confluence{
+		spaces:     map[string]string{},
+		pages:      map[string]*Page{},
+		users:      map[string]confluence.User{},
+		properties: map[string]map[string]*confluence.ContentProperty{},
+	}
+}
+
+// AddSpace creates space with given key and home page with given title and
+// returns ID of the home page.
+func (fake *Confluence) AddSpace(key string, home string) string {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	page := fake.addPage(confluence.ContentTypePage, key, "", home, "")
+
+	fake.spaces[key] = page.ID
+
+	return page.ID
+}
+
+// AddUser registers user which can be found by full name.
+func (fake *Confluence) AddUser(name string, accountID string) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	fake.users[name] = confluence.User{AccountID: accountID}
+}
+
+// Page returns copy of stored page or nil if there is no page with given ID.
+func (fake *Confluence) Page(pageID string) *Page {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	page, ok := fake.pages[pageID]
+	if !ok {
+		return nil
+	}
+
+	clone := *page
+
+	return &clone
+}
+
+// Pages returns copies of all stored pages ordered by ID.
+func (fake *Confluence) Pages() []*Page {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	pages := []*Page{}
+	for _, page := range fake.pages {
+		clone := *page
+		pages = append(pages, &clone)
+	}
+
+	sort.Slice(pages, func(i, j int) bool {
+		return compareIDs(pages[i].ID, pages[j].ID)
+	})
+
+	return pages
+}
+
+func (fake *Confluence) FindRootPage(space string) (*confluence.PageInfo, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	id, ok := fake.spaces[space]
+	if !ok {
+		return nil, fmt.Errorf("no such space")
+	}
+
+	return fake.getPageInfo(fake.pages[id]), nil
+}
+
+func (fake *Confluence) FindPage(
+	space string,
+	title string,
+) (*confluence.PageInfo, error) {
+	if title == "" {
+		return fake.FindRootPage(space)
+	}
+
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	page := fake.findPage(confluence.ContentTypePage, space, title)
+	if page == nil {
+		return nil, nil
+	}
+
+	return fake.getPageInfo(page), nil
+}
+
+func (fake *Confluence) FindBlogPost(
+	space string,
+	title string,
+	date string,
+) (*confluence.PageInfo, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	post := fake.findPage(confluence.ContentTypeBlogPost, space, title)
+	if post == nil || (date != "" && post.Created != date) {
+		return nil, nil
+	}
+
+	return fake.getPageInfo(post), nil
+}
+
+func (fake *Confluence) CreateBlogPost(
+	space string,
+	title string,
+	body string,
+) (*confluence.PageInfo, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	if _, ok := fake.spaces[space]; !ok {
+		return nil, fmt.Errorf("no such space")
+	}
+
+	post := fake.addPage(confluence.ContentTypeBlogPost, space, "", title, body)
+
+	return fake.getPageInfo(post), nil
+}
+
+func (fake *Confluence) GetPageByID(pageID string) (*confluence.PageInfo, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	page, err := fake.getPage(pageID)
+	if err != nil {
+		return nil, err
+	}
+
+	return fake.getPageInfo(page), nil
+}
+
+func (fake *Confluence) GetPageBody(
+	pageID string,
+	version int64,
+) (string, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	page, err := fake.getPage(pageID)
+	if err != nil {
+		return "", err
+	}
+
+	if version == 0 || version == page.Version {
+		return page.Body, nil
+	}
+
+	if version < 0 || version > int64(len(page.History)) {
+		return "", fmt.Errorf(
+			"Confluence API returned unexpected status: 404 (Not Found)",
+		)
+	}
+
+	return page.History[version-1], nil
+}
+
+func (fake *Confluence) CreatePage(
+	space string,
+	parent *confluence.PageInfo,
+	title string,
+	body string,
+) (*confluence.PageInfo, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	if _, ok := fake.spaces[space]; !ok {
+		return nil, fmt.Errorf("no such space")
+	}
+
+	if fake.findPage(confluence.ContentTypePage, space, title) != nil {
+		return nil, fmt.Errorf(
+			"a page with title %q already exists in space %q",
+			title,
+			space,
+		)
+	}
+
+	var parentID string
+	if parent != nil {
+		if _, err := fake.getPage(parent.ID); err != nil {
+			return nil, err
+		}
+
+		parentID = parent.ID
+	}
+
+	page := fake.addPage(
+		confluence.ContentTypePage,
+		space,
+		parentID,
+		title,
+		body,
+	)
+
+	return fake.getPageInfo(page), nil
+}
+
+func (fake *Confluence) UpdatePage(
+	info *confluence.PageInfo,
+	newContent string,
+) error {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	page, err := fake.getPage(info.ID)
+	if err != nil {
+		return err
+	}
+
+	if info.Version.Number != page.Version {
+		return fmt.Errorf(
+			"version conflict: page %q has version %d, but %d is updated",
+			page.ID,
+			page.Version,
+			info.Version.Number,
+		)
+	}
+
+	if page.Type == confluence.ContentTypePage && len(info.Ancestors) > 0 {
+		parentID := info.Ancestors[len(info.Ancestors)-1].Id
+		if _, err := fake.getPage(parentID); err != nil {
+			return err
+		}
+
+		page.ParentID = parentID
+	}
+
+	if info.Title != page.Title {
+		if fake.findPage(page.Type, page.Space, info.Title) != nil {
+			return fmt.Errorf(
+				"a page with title %q already exists in space %q",
+				info.Title,
+				page.Space,
+			)
+		}
+	}
+
+	page.Title = info.Title
+	page.History = append(page.History, page.Body)
+	page.Body = newContent
+	page.Version++
+
+	info.Version.Number = page.Version
+
+	return nil
+}
+
+func (fake *Confluence) RestrictPageUpdates(
+	info *confluence.PageInfo,
+	allowedUser string,
+) error {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	page, err := fake.getPage(info.ID)
+	if err != nil {
+		return err
+	}
+
+	page.RestrictedTo = allowedUser
+
+	return nil
+}
+
+func (fake *Confluence) GetChildPages(
+	pageID string,
+) ([]confluence.PageInfo, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	if _, err := fake.getPage(pageID); err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, page := range fake.pages {
+		if page.ParentID == pageID && page.Status == statusCurrent {
+			ids = append(ids, page.ID)
+		}
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return compareIDs(ids[i], ids[j])
+	})
+
+	pages := []confluence.PageInfo{}
+	for _, id := range ids {
+		pages = append(pages, *fake.getPageInfo(fake.pages[id]))
+	}
+
+	return pages, nil
+}
+
+func (fake *Confluence) DeletePage(pageID string) error {
+	return fake.setStatus(pageID, statusTrashed)
+}
+
+func (fake *Confluence) ArchivePage(pageID string) error {
+	return fake.setStatus(pageID, statusArchived)
+}
+
+func (fake *Confluence) setStatus(pageID string, status string) error {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	page, err := fake.getPage(pageID)
+	if err != nil {
+		return err
+	}
+
+	if fake.spaces[page.Space] == page.ID {
+		return fmt.Errorf("space home page %q can't be removed", pageID)
+	}
+
+	// the same as Confluence does, children of removed page are moved to
+	// its parent
+	for _, child := range fake.pages {
+		if child.ParentID == page.ID && child.Status == statusCurrent {
+			child.ParentID = page.ParentID
+		}
+	}
+
+	page.Status = status
+
+	return nil
+}
+
+func (fake *Confluence) GetPageLabels(pageID string) ([]confluence.Label, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	page, err := fake.getPage(pageID)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := []confluence.Label{}
+	for _, name := range page.Labels {
+		labels = append(labels, confluence.Label{
+			Prefix: "global",
+			Name:   name,
+		})
+	}
+
+	return labels, nil
+}
+
+func (fake *Confluence) AddPageLabels(pageID string, labels []string) error {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	page, err := fake.getPage(pageID)
+	if err != nil {
+		return err
+	}
+
+	for _, label := range labels {
+		if !contains(page.Labels, label) {
+			page.Labels = append(page.Labels, label)
+		}
+	}
+
+	return nil
+}
+
+func (fake *Confluence) RemovePageLabel(pageID string, label string) error {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	page, err := fake.getPage(pageID)
+	if err != nil {
+		return err
+	}
+
+	for i, name := range page.Labels {
+		if name == label {
+			page.Labels = append(page.Labels[:i:i], page.Labels[i+1:]...)
+
+			break
+		}
+	}
+
+	return nil
+}
+
+func (fake *Confluence) FindPagesByLabel(
+	space string,
+	label string,
+) ([]confluence.PageInfo, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	pages := []confluence.PageInfo{}
+	for _, page := range fake.pages {
+		if page.Space == space &&
+			page.Status == statusCurrent &&
+			contains(page.Labels, label) {
+			pages = append(pages, *fake.getPageInfo(page))
+		}
+	}
+
+	sort.Slice(pages, func(i, j int) bool {
+		return compareIDs(pages[i].ID, pages[j].ID)
+	})
+
+	return pages, nil
+}
+
+func (fake *Confluence) GetContentProperty(
+	contentID string,
+	key string,
+) (*confluence.ContentProperty, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	property, ok := fake.properties[contentID][key]
+	if !ok {
+		return nil, nil
+	}
+
+	clone := *property
+
+	return &clone, nil
+}
+
+func (fake *Confluence) SetContentProperty(
+	contentID string,
+	key string,
+	value interface{},
+) error {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	if _, ok := fake.properties[contentID]; !ok {
+		fake.properties[contentID] = map[string]*confluence.ContentProperty{}
+	}
+
+	property, ok := fake.properties[contentID][key]
+	if ok && reflect.DeepEqual(property.Value, value) {
+		return nil
+	}
+
+	if !ok {
+		property = &confluence.ContentProperty{
+			ID:  fake.nextID(),
+			Key: key,
+		}
+
+		fake.properties[contentID][key] = property
+	}
+
+	property.Value = value
+	property.Version.Number++
+
+	return nil
+}
+
+func (fake *Confluence) DeleteContentProperty(
+	contentID string,
+	key string,
+) error {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	delete(fake.properties[contentID], key)
+
+	return nil
+}
+
+func (fake *Confluence) GetAttachments(
+	pageID string,
+) ([]confluence.AttachmentInfo, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	page, err := fake.getPage(pageID)
+	if err != nil {
+		return nil, err
+	}
+
+	attachments := []confluence.AttachmentInfo{}
+	for _, attachment := range page.Attachments {
+		attachments = append(
+			attachments,
+			getAttachmentInfo(page, attachment),
+		)
+	}
+
+	return attachments, nil
+}
+
+func (fake *Confluence) CreateAttachment(
+	pageID string,
+	name string,
+	comment string,
+	path string,
+) (confluence.AttachmentInfo, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	page, err := fake.getPage(pageID)
+	if err != nil {
+		return confluence.AttachmentInfo{}, err
+	}
+
+	for _, attachment := range page.Attachments {
+		if attachment.Filename == name {
+			return confluence.AttachmentInfo{}, fmt.Errorf(
+				"attachment %q already exists on page %q",
+				name,
+				pageID,
+			)
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return confluence.AttachmentInfo{}, err
+	}
+
+	attachment := &Attachment{
+		ID:       fake.nextID(),
+		Filename: name,
+		Comment:  comment,
+		Version:  1,
+		Data:     data,
+	}
+
+	page.Attachments = append(page.Attachments, attachment)
+
+	return getAttachmentInfo(page, attachment), nil
+}
+
+func (fake *Confluence) UpdateAttachment(
+	pageID string,
+	attachID string,
+	name string,
+	comment string,
+	path string,
+) (confluence.AttachmentInfo, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	page, err := fake.getPage(pageID)
+	if err != nil {
+		return confluence.AttachmentInfo{}, err
+	}
+
+	for _, attachment := range page.Attachments {
+		if attachment.ID != attachID {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return confluence.AttachmentInfo{}, err
+		}
+
+		attachment.Comment = comment
+		attachment.Data = data
+		attachment.Version++
+
+		return getAttachmentInfo(page, attachment), nil
+	}
+
+	return confluence.AttachmentInfo{}, fmt.Errorf(
+		"attachment %q not found on page %q",
+		attachID,
+		pageID,
+	)
+}
+
+func (fake *Confluence) DeleteAttachment(attachID string) error {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	for _, page := range fake.pages {
+		for i, attachment := range page.Attachments {
+			if attachment.ID != attachID {
+				continue
+			}
+
+			page.Attachments = append(
+				page.Attachments[:i:i],
+				page.Attachments[i+1:]...,
+			)
+
+			return nil
+		}
+	}
+
+	return fmt.Errorf(
+		"Confluence API returned unexpected status: 404 (Not Found)",
+	)
+}
+
+func (fake *Confluence) DownloadAttachment(
+	info confluence.AttachmentInfo,
+	writer io.Writer,
+) error {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	for _, page := range fake.pages {
+		for _, attachment := range page.Attachments {
+			if attachment.ID != info.ID {
+				continue
+			}
+
+			_, err := writer.Write(attachment.Data)
+
+			return err
+		}
+	}
+
+	return fmt.Errorf(
+		"Confluence returned unexpected status: 404 Not Found, "+
+			"while downloading attachment %q",
+		info.Filename,
+	)
+}
+
+func (fake *Confluence) GetUserByName(name string) (*confluence.User, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	user, ok := fake.users[name]
+	if !ok {
+		return nil, fmt.Errorf("user with given name is not found: %q", name)
+	}
+
+	return &user, nil
+}
+
+func (fake *Confluence) nextID() string {
+	fake.sequence++
+
+	return strconv.FormatInt(fake.sequence, 10)
+}
+
+func (fake *Confluence) addPage(
+	contentType string,
+	space string,
+	parentID string,
+	title string,
+	body string,
+) *Page {
+	page := &Page{
+		ID:       fake.nextID(),
+		Type:     contentType,
+		Space:    space,
+		Title:    title,
+		ParentID: parentID,
+		Version:  1,
+		Body:     body,
+		Status:   statusCurrent,
+		Created:  time.Now().Format("2006-01-02"),
+	}
+
+	fake.pages[page.ID] = page
+
+	return page
+}
+
+func (fake *Confluence) getPage(pageID string) (*Page, error) {
+	page, ok := fake.pages[pageID]
+	if !ok || page.Status != statusCurrent {
+		return nil, fmt.Errorf(
+			"Confluence API returned unexpected status: 404 (Not Found)",
+		)
+	}
+
+	return page, nil
+}
+
+func (fake *Confluence) findPage(
+	contentType string,
+	space string,
+	title string,
+) *Page {
+	for _, page := range fake.pages {
+		if page.Type == contentType &&
+			page.Space == space &&
+			page.Title == title &&
+			page.Status == statusCurrent {
+			return page
+		}
+	}
+
+	return nil
+}
+
+func (fake *Confluence) getPageInfo(page *Page) *confluence.PageInfo {
+	info := &confluence.PageInfo{
+		ID:    page.ID,
+		Type:  page.Type,
+		Title: page.Title,
+	}
+
+	info.Version.Number = page.Version
+	info.Links.Full = "/pages/viewpage.action?pageId=" + page.ID
+
+	for id := page.ParentID; id != ""; id = fake.pages[id].ParentID {
+		info.Ancestors = append(
+			[]confluence.PageAncestor{{
+				Id:    id,
+				Title: fake.pages[id].Title,
+			}},
+			info.Ancestors...,
+		)
+	}
+
+	return info
+}
+
+func getAttachmentInfo(
+	page *Page,
+	attachment *Attachment,
+) confluence.AttachmentInfo {
+	var info confluence.AttachmentInfo
+
+	info.ID = attachment.ID
+	info.Filename = attachment.Filename
+	info.Metadata.Comment = attachment.Comment
+	info.Links.Download = fmt.Sprintf(
+		"/download/attachments/%s/%s?version=%d",
+		page.ID,
+		attachment.Filename,
+		attachment.Version,
+	)
+
+	return info
+}
+
+func compareIDs(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+
+	return a < b
+}
+
+func contains(list []string, item string) bool {
+	for _, value := range list {
+		if value == item {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,174 @@
+package confluence
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// query holds query parameters which request should have to match route.
+type query map[string]string
+
+type fixtureRoute struct {
+	method  string
+	path    string
+	query   query
+	status  int
+	fixture string
+}
+
+type fixtureRequest struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	body   []byte
+}
+
+// fixtureServer replies to requests with responses recorded from Confluence
+// which are stored in testdata directory.
+type fixtureServer struct {
+	*httptest.Server
+
+	t *testing.T
+
+	mutex    sync.Mutex
+	routes   []fixtureRoute
+	requests []fixtureRequest
+}
+
+func newFixtureServer(t *testing.T) *fixtureServer {
+	server := &fixtureServer{t: t}
+
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serve))
+
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// on registers route, routes are matched in order of registration. Empty
+// fixture means that response has no body.
+func (server *fixtureServer) on(
+	method string,
+	path string,
+	params query,
+	status int,
+	fixture string,
+) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.routes = append(server.routes, fixtureRoute{
+		method:  method,
+		path:    path,
+		query:   params,
+		status:  status,
+		fixture: fixture,
+	})
+}
+
+func (server *fixtureServer) serve(
+	writer http.ResponseWriter,
+	request *http.Request,
+) {
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		server.t.Errorf("unable to read request body: %s", err)
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.requests = append(server.requests, fixtureRequest{
+		method: request.Method,
+		path:   request.URL.Path,
+		query:  request.URL.Query(),
+		header: request.Header,
+		body:   body,
+	})
+
+	for _, route := range server.routes {
+		if !route.match(request) {
+			continue
+		}
+
+		var response []byte
+		if route.fixture != "" {
+			response, err = ioutil.ReadFile(
+				filepath.Join("testdata", route.fixture),
+			)
+			if err != nil {
+				server.t.Errorf("unable to read fixture: %s", err)
+			}
+
+			writer.Header().Set("Content-Type", "application/json")
+		}
+
+		writer.WriteHeader(route.status)
+		writer.Write(response)
+
+		return
+	}
+
+	server.t.Errorf(
+		"unexpected request: %s %s",
+		request.Method,
+		request.URL.String(),
+	)
+
+	writer.WriteHeader(http.StatusNotImplemented)
+}
+
+func (route fixtureRoute) match(request *http.Request) bool {
+	if route.method != request.Method || route.path != request.URL.Path {
+		return false
+	}
+
+	for key, value := range route.query {
+		if request.URL.Query().Get(key) != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// request returns the last request with given method and path.
+func (server *fixtureServer) request(
+	method string,
+	path string,
+) fixtureRequest {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	for i := len(server.requests) - 1; i >= 0; i-- {
+		request := server.requests[i]
+		if request.method == method && request.path == path {
+			return request
+		}
+	}
+
+	server.t.Fatalf("no request was made: %s %s", method, path)
+
+	return fixtureRequest{}
+}
+
+// count returns number of requests with given method and path.
+func (server *fixtureServer) count(method string, path string) int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	count := 0
+	for _, request := range server.requests {
+		if request.method == method && request.path == path {
+			count++
+		}
+	}
+
+	return count
+}
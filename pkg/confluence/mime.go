@@ -0,0 +1,77 @@
+package confluence
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/reconquest/karma-go"
+)
+
+// contentTypes contains types which are not known to mime package on every
+// system, but are required for Confluence to preview attachments.
+var contentTypes = map[string]string{
+	".svg":  "image/svg+xml",
+	".pdf":  "application/pdf",
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".mov":  "video/quicktime",
+	".webm": "video/webm",
+	".avi":  "video/x-msvideo",
+	".mp3":  "audio/mpeg",
+	".wav":  "audio/wav",
+	".ogg":  "audio/ogg",
+	".doc":  "application/msword",
+	".xls":  "application/vnd.ms-excel",
+	".ppt":  "application/vnd.ms-powerpoint",
+	".docx": "application/vnd.openxmlformats-officedocument." +
+		"wordprocessingml.document",
+	".xlsx": "application/vnd.openxmlformats-officedocument." +
+		"spreadsheetml.sheet",
+	".pptx": "application/vnd.openxmlformats-officedocument." +
+		"presentationml.presentation",
+}
+
+// getContentType detects content type of attachment file by its extension
+// and falls back to sniffing of its content. File offset is restored.
+func getContentType(file *os.File, name string) (string, error) {
+	for _, path := range []string{name, file.Name()} {
+		extension := strings.ToLower(filepath.Ext(path))
+		if extension == "" {
+			continue
+		}
+
+		if contentType, ok := contentTypes[extension]; ok {
+			return contentType, nil
+		}
+
+		if contentType := mime.TypeByExtension(extension); contentType != "" {
+			return contentType, nil
+		}
+	}
+
+	head := make([]byte, 512)
+
+	size, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", karma.Format(
+			err,
+			"unable to read file: %q",
+			file.Name(),
+		)
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", karma.Format(
+			err,
+			"unable to seek file: %q",
+			file.Name(),
+		)
+	}
+
+	return http.DetectContentType(head[:size]), nil
+}
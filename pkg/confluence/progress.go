@@ -0,0 +1,46 @@
+package confluence
+
+import (
+	"io"
+
+	"github.com/kovetskiy/mark/pkg/log"
+)
+
+// progressThreshold is a minimal size of uploaded file which upload progress
+// is reported for.
+const progressThreshold = 10 * 1024 * 1024
+
+// progressReader reports upload progress of attachment every 10 percents.
+type progressReader struct {
+	reader io.Reader
+	name   string
+	size   int64
+	read   int64
+	step   int64
+}
+
+func (progress *progressReader) Read(data []byte) (int, error) {
+	size, err := progress.reader.Read(data)
+
+	progress.read += int64(size)
+
+	if progress.size < progressThreshold {
+		return size, err
+	}
+
+	step := progress.read * 10 / progress.size
+	if step > progress.step {
+		progress.step = step
+
+		log.Infof(
+			nil,
+			"uploading attachment %q: %d%% (%d of %d bytes)",
+			progress.name,
+			step*10,
+			progress.read,
+			progress.size,
+		)
+	}
+
+	return size, err
+}
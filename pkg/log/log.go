@@ -1,6 +1,8 @@
 package log
 
 import (
+	"fmt"
+
 	"github.com/kovetskiy/lorg"
 	"github.com/reconquest/cog"
 	"github.com/reconquest/karma-go"
@@ -49,7 +51,9 @@ func Warningf(
 	message string,
 	args ...interface{},
 ) {
-	log.Warningf(reason, message, args...)
+	if recordWarning(reason, message, args...) {
+		log.Warningf(reason, message, args...)
+	}
 }
 
 func Infof(
@@ -85,7 +89,9 @@ func Error(values ...interface{}) {
 }
 
 func Warning(values ...interface{}) {
-	log.Warning(values...)
+	if recordWarning(nil, "%s", fmt.Sprint(values...)) {
+		log.Warning(values...)
+	}
 }
 
 func Info(values ...interface{}) {
@@ -0,0 +1,63 @@
+package log
+
+import (
+	"fmt"
+	"sync"
+)
+
+var (
+	warnings      []string
+	warningsMutex sync.Mutex
+
+	// warningsQuiet disables printing of warnings, they are only recorded
+	warningsQuiet bool
+)
+
+// SetWarningsQuiet sets whether warnings are only recorded without being
+// printed, so the caller which reports recorded warnings itself doesn't
+// show them twice.
+func SetWarningsQuiet(quiet bool) {
+	warningsMutex.Lock()
+	defer warningsMutex.Unlock()
+
+	warningsQuiet = quiet
+}
+
+// TakeWarnings returns messages of warnings logged since previous call, so
+// they can be reported along with the result of processing of the file.
+// Errors are not recorded, because they are reported by the caller which
+// handles them.
+func TakeWarnings() []string {
+	warningsMutex.Lock()
+	defer warningsMutex.Unlock()
+
+	taken := warnings
+	warnings = nil
+
+	return taken
+}
+
+// GetWarnings returns messages of warnings logged since last TakeWarnings
+// call without removing them.
+func GetWarnings() []string {
+	warningsMutex.Lock()
+	defer warningsMutex.Unlock()
+
+	return append([]string{}, warnings...)
+}
+
+// recordWarning records the warning and returns true if it should be
+// printed.
+func recordWarning(reason error, message string, args ...interface{}) bool {
+	text := fmt.Sprintf(message, args...)
+	if reason != nil {
+		text += ": " + reason.Error()
+	}
+
+	warningsMutex.Lock()
+	defer warningsMutex.Unlock()
+
+	warnings = append(warnings, text)
+
+	return !warningsQuiet
+}
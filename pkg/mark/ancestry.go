@@ -11,7 +11,7 @@ import (
 
 func EnsureAncestry(
 	dryRun bool,
-	api *confluence.API,
+	api confluence.Client,
 	space string,
 	ancestry []string,
 ) (*confluence.PageInfo, error) {
@@ -91,7 +91,7 @@ func EnsureAncestry(
 }
 
 func ValidateAncestry(
-	api *confluence.API,
+	api confluence.Client,
 	space string,
 	ancestry []string,
 ) (*confluence.PageInfo, error) {
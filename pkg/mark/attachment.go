@@ -4,23 +4,37 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"io"
 	"net/url"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 
 	"github.com/kovetskiy/mark/pkg/confluence"
 	"github.com/kovetskiy/mark/pkg/log"
+	"github.com/kovetskiy/mark/pkg/mark/position"
 	"github.com/reconquest/karma-go"
 )
 
 const (
+	// PropertyChecksum is a content property of attachment which holds
+	// checksum of uploaded file.
+	PropertyChecksum = `mark-checksum`
+
+	// AttachmentChecksumPrefix is used by previous versions of mark, which
+	// stored checksum in attachment comment.
 	AttachmentChecksumPrefix = `mark:checksum: `
 )
 
+var reAttachmentAlias = regexp.MustCompile(`^(.+)\s+as\s+(.+)$`)
+
+var reAttachmentAs = regexp.MustCompile(`\s+as\s+`)
+
 type Attachment struct {
 	ID       string
 	Name     string
@@ -29,88 +43,410 @@ type Attachment struct {
 	Checksum string
 	Link     string
 	Replace  string
+
+	// Changed is set when attachment was created or updated.
+	Changed bool
+
+	// Position is a position of Attachment header which declared the
+	// attachment.
+	Position position.Position
+
+	// legacy is a name which previous versions of mark gave to the
+	// attachment, it's used instead of Filename if page already has
+	// attachment with such name.
+	legacy string
 }
 
+// ResolveAttachments uploads new and changed attachments to the page. Given
+// comment is set to uploaded attachments, if it's empty, subject of the last
+// git commit which changed attachment file is used. Attachments larger than
+// limit bytes are refused, zero limit means no limit.
+//
+// Declared attachments are paths relative to base directory, glob patterns
+// or directories, see ExpandAttachments.
+//
+// Attachments are hashed and uploaded concurrently, but returned in order of
+// their names. If some attachments fail, errors of all of them are returned.
 func ResolveAttachments(
-	api *confluence.API,
+	api confluence.Client,
 	page *confluence.PageInfo,
 	base string,
-	replacements map[string]string,
+	declared []Header,
+	comment string,
+	limit int64,
 ) ([]Attachment, error) {
-	attaches := []Attachment{}
-	for replace, name := range replacements {
-		attach := Attachment{
-			Name:     name,
-			Filename: strings.ReplaceAll(name, "/", "_"),
-			Path:     filepath.Join(base, name),
-			Replace:  replace,
+	attaches, err := ExpandAttachments(base, declared)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(attaches, func(i, j int) bool {
+		return attaches[i].Name < attaches[j].Name
+	})
+
+	remotes, err := api.GetAttachments(page.ID)
+	if err != nil {
+		return nil, karma.Format(
+			err,
+			"unable to get attachments of page %q",
+			page.Title,
+		)
+	}
+
+	errs := runParallel(len(attaches), func(i int) error {
+		return resolveAttachment(
+			api,
+			page,
+			remotes,
+			&attaches[i],
+			comment,
+			limit,
+		)
+	})
+	if len(errs) > 0 {
+		return nil, pushErrors(
+			fmt.Sprintf("%d of %d attachments failed", len(errs), len(attaches)),
+			errs,
+		)
+	}
+
+	return attaches, nil
+}
+
+// ExpandAttachments converts values of Attachment headers into the list of
+// attachments. Every value is one of:
+//
+//   - path to file, which can be followed by 'as <name>' to set name of
+//     uploaded attachment: 'images/diagram-v2.png as diagram.png';
+//   - glob pattern: 'images/*.png';
+//   - path to directory, all files in it are attached recursively.
+//
+// Paths are relative to base directory, which is directory of markdown file.
+// Paths relative to current directory are still accepted for compatibility.
+func ExpandAttachments(base string, declared []Header) ([]Attachment, error) {
+	var (
+		attaches = []Attachment{}
+		seen     = map[string]string{}
+	)
+
+	for _, header := range declared {
+		var (
+			value = header.Value
+			at    = header.Position
+		)
+
+		name, alias := splitAttachmentAlias(base, value)
+
+		root := base
+
+		paths, err := findAttachmentFiles(root, name)
+		if err != nil {
+			return nil, karma.Format(err, "%sinvalid attachment", at.Prefix())
+		}
+
+		if len(paths) == 0 && base != "." {
+			paths, err = findAttachmentFiles(".", name)
+			if err != nil {
+				return nil, karma.Format(
+					err,
+					"%sinvalid attachment",
+					at.Prefix(),
+				)
+			}
+
+			if len(paths) > 0 {
+				log.Warningf(
+					nil,
+					"%sattachment %q is found relative to current directory, "+
+						"paths should be relative to markdown file directory %q",
+					at.Prefix(),
+					name,
+					base,
+				)
+
+				root = "."
+			}
+		}
+
+		if len(paths) == 0 {
+			// reported as error while calculating checksum
+			paths = []string{filepath.Join(base, name)}
+		}
+
+		// path to single file is kept as written, because links to the file
+		// in markdown use the same form
+		single := len(paths) == 1 &&
+			paths[0] == filepath.Join(root, filepath.FromSlash(name))
+
+		if alias != "" {
+			if !single {
+				return nil, fmt.Errorf(
+					"%sattachment %q: alias can be used only with single file",
+					at.Prefix(),
+					value,
+				)
+			}
+		}
+
+		for _, path := range paths {
+			relative, err := filepath.Rel(root, path)
+			if err != nil {
+				return nil, karma.Format(
+					err,
+					"unable to get relative path of attachment %q",
+					path,
+				)
+			}
+
+			attach := Attachment{
+				Name:     filepath.ToSlash(relative),
+				Path:     path,
+				Filename: alias,
+				Position: at,
+			}
+
+			attach.Replace = attach.Name
+			if single {
+				attach.Replace = name
+			}
+
+			if attach.Filename == "" {
+				attach.Filename = GetAttachmentFilename(attach.Name)
+				attach.legacy = GetAttachmentFilename(attach.Replace)
+			}
+
+			if previous, ok := seen[attach.Filename]; ok {
+				if previous == attach.Path {
+					continue
+				}
+
+				return nil, fmt.Errorf(
+					"%sattachments %q and %q have the same name %q",
+					at.Prefix(),
+					previous,
+					attach.Path,
+					attach.Filename,
+				)
+			}
+
+			seen[attach.Filename] = attach.Path
+
+			attaches = append(attaches, attach)
+		}
+	}
+
+	return attaches, nil
+}
+
+// splitAttachmentAlias splits value of Attachment header into path and alias.
+// Both of them may contain " as ", so the value is a path if it exists as
+// is, otherwise it's split at the last " as " which is preceded by existing
+// path, or at the last " as " if there is no such path.
+func splitAttachmentAlias(base string, value string) (string, string) {
+	if hasAttachmentFiles(base, value) {
+		return value, ""
+	}
+
+	separators := reAttachmentAs.FindAllStringIndex(value, -1)
+	for i := len(separators) - 1; i >= 0; i-- {
+		name := value[:separators[i][0]]
+		if hasAttachmentFiles(base, name) {
+			return name, value[separators[i][1]:]
+		}
+	}
+
+	if parts := reAttachmentAlias.FindStringSubmatch(value); parts != nil {
+		return parts[1], parts[2]
+	}
+
+	return value, ""
+}
+
+// hasAttachmentFiles returns true if given attachment path matches any files
+// relative to base or current directory.
+func hasAttachmentFiles(base string, name string) bool {
+	for _, root := range []string{base, "."} {
+		matches, err := filepath.Glob(
+			filepath.Join(root, filepath.FromSlash(name)),
+		)
+		if err == nil && len(matches) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetAttachmentFilename returns name which attachment of single file with
+// given path is uploaded under if no alias is specified.
+func GetAttachmentFilename(path string) string {
+	return strings.ReplaceAll(path, "/", "_")
+}
+
+// findAttachmentFiles returns files matched by given attachment path, glob
+// pattern or directory relative to base directory.
+func findAttachmentFiles(base string, name string) ([]string, error) {
+	pattern := filepath.Join(base, filepath.FromSlash(name))
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, karma.Format(
+			err,
+			"invalid attachment pattern: %q",
+			name,
+		)
+	}
+
+	paths := []string{}
+	for _, match := range matches {
+		stat, err := os.Stat(match)
+		if err != nil {
+			return nil, karma.Format(
+				err,
+				"unable to stat attachment: %q",
+				match,
+			)
+		}
+
+		if !stat.IsDir() {
+			paths = append(paths, match)
+
+			continue
 		}
 
-		checksum, err := getChecksum(attach.Path)
+		err = filepath.Walk(
+			match,
+			func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+
+				if strings.HasPrefix(info.Name(), ".") && path != match {
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+
+					return nil
+				}
+
+				if info.Mode().IsRegular() {
+					paths = append(paths, path)
+				}
+
+				return nil
+			},
+		)
 		if err != nil {
 			return nil, karma.Format(
 				err,
-				"unable to get checksum for attachment: %q", attach.Name,
+				"unable to walk attachment directory: %q",
+				match,
 			)
 		}
+	}
 
-		attach.Checksum = checksum
+	sort.Strings(paths)
 
-		attaches = append(attaches, attach)
+	return paths, nil
+}
+
+// resolveAttachment calculates checksum of given attachment and uploads it
+// if there is no remote attachment with the same checksum.
+func resolveAttachment(
+	api confluence.Client,
+	page *confluence.PageInfo,
+	remotes []confluence.AttachmentInfo,
+	attach *Attachment,
+	comment string,
+	limit int64,
+) error {
+	if limit > 0 {
+		stat, err := os.Stat(attach.Path)
+		if err != nil {
+			return karma.Format(
+				err,
+				"%sunable to stat attachment: %q",
+				attach.Position.Prefix(),
+				attach.Name,
+			)
+		}
+
+		if stat.Size() > limit {
+			return fmt.Errorf(
+				"%sattachment %q is too large: %d bytes, limit is %d bytes",
+				attach.Position.Prefix(),
+				attach.Name,
+				stat.Size(),
+				limit,
+			)
+		}
 	}
 
-	remotes, err := api.GetAttachments(page.ID)
+	checksum, err := getChecksum(attach.Path)
 	if err != nil {
-		panic(err)
+		return karma.Format(
+			err,
+			"%sunable to get checksum for attachment: %q",
+			attach.Position.Prefix(),
+			attach.Name,
+		)
 	}
 
-	existing := []Attachment{}
-	creating := []Attachment{}
-	updating := []Attachment{}
-	for _, attach := range attaches {
-		var found bool
-		var same bool
-		for _, remote := range remotes {
-			if remote.Filename == attach.Filename {
-				same = attach.Checksum == strings.TrimPrefix(
-					remote.Metadata.Comment,
-					AttachmentChecksumPrefix,
-				)
+	attach.Checksum = checksum
 
-				attach.ID = remote.ID
-				attach.Link = path.Join(
-					remote.Links.Context,
-					remote.Links.Download,
-				)
+	if attach.legacy != "" && attach.legacy != attach.Filename &&
+		hasAttachment(remotes, attach.legacy) &&
+		!hasAttachment(remotes, attach.Filename) {
+		log.Debugf(
+			nil,
+			"using previous name of attachment %q: %q",
+			attach.Name,
+			attach.legacy,
+		)
 
-				found = true
+		attach.Filename = attach.legacy
+	}
 
-				break
-			}
+	for _, remote := range remotes {
+		if remote.Filename != attach.Filename {
+			continue
 		}
 
-		if found {
-			if same {
-				existing = append(existing, attach)
-			} else {
-				updating = append(updating, attach)
-			}
-		} else {
-			creating = append(creating, attach)
+		checksum, err := getRemoteChecksum(api, remote)
+		if err != nil {
+			return karma.Format(
+				err,
+				"unable to get checksum of remote attachment: %q",
+				remote.Filename,
+			)
 		}
+
+		attach.ID = remote.ID
+		attach.Link = path.Join(
+			remote.Links.Context,
+			remote.Links.Download,
+		)
+
+		if attach.Checksum == checksum {
+			return nil
+		}
+
+		break
 	}
 
-	for i, attach := range creating {
+	var info confluence.AttachmentInfo
+
+	if attach.ID == "" {
 		log.Infof(nil, "creating attachment: %q", attach.Name)
 
-		info, err := api.CreateAttachment(
+		info, err = api.CreateAttachment(
 			page.ID,
 			attach.Filename,
-			AttachmentChecksumPrefix+attach.Checksum,
+			getAttachmentComment(attach.Path, comment),
 			attach.Path,
 		)
 		if err != nil {
-			return nil, karma.Format(
+			return karma.Format(
 				err,
 				"unable to create attachment %q",
 				attach.Name,
@@ -118,62 +454,154 @@ func ResolveAttachments(
 		}
 
 		attach.ID = info.ID
-		attach.Link = path.Join(
-			info.Links.Context,
-			info.Links.Download,
-		)
-
-		creating[i] = attach
-	}
-
-	for i, attach := range updating {
+	} else {
 		log.Infof(nil, "updating attachment: %q", attach.Name)
 
-		info, err := api.UpdateAttachment(
+		info, err = api.UpdateAttachment(
 			page.ID,
 			attach.ID,
-			attach.Name,
-			AttachmentChecksumPrefix+attach.Checksum,
+			attach.Filename,
+			getAttachmentComment(attach.Path, comment),
 			attach.Path,
 		)
 		if err != nil {
-			return nil, karma.Format(
+			return karma.Format(
 				err,
 				"unable to update attachment %q",
 				attach.Name,
 			)
 		}
+	}
 
-		attach.Link = path.Join(
-			info.Links.Context,
-			info.Links.Download,
+	err = api.SetContentProperty(attach.ID, PropertyChecksum, attach.Checksum)
+	if err != nil {
+		return karma.Format(
+			err,
+			"unable to set checksum of attachment %q",
+			attach.Name,
 		)
+	}
+
+	attach.Link = path.Join(
+		info.Links.Context,
+		info.Links.Download,
+	)
 
-		updating[i] = attach
+	attach.Changed = true
+
+	return nil
+}
+
+// PruneAttachments removes attachments uploaded by mark which are not in the
+// given list of resolved attachments anymore and returns names of removed
+// attachments. Attachments uploaded by other means are never removed.
+func PruneAttachments(
+	api confluence.Client,
+	page *confluence.PageInfo,
+	attaches []Attachment,
+) ([]string, error) {
+	remotes, err := api.GetAttachments(page.ID)
+	if err != nil {
+		return nil, karma.Format(
+			err,
+			"unable to get attachments of page %q",
+			page.Title,
+		)
 	}
 
-	attaches = []Attachment{}
-	attaches = append(attaches, existing...)
-	attaches = append(attaches, creating...)
-	attaches = append(attaches, updating...)
+	removed := []string{}
 
-	return attaches, nil
+	used := map[string]bool{}
+	for _, attach := range attaches {
+		used[attach.Filename] = true
+	}
+
+	for _, remote := range remotes {
+		if used[remote.Filename] {
+			continue
+		}
+
+		checksum, err := getRemoteChecksum(api, remote)
+		if err != nil {
+			return nil, karma.Format(
+				err,
+				"unable to get checksum of remote attachment: %q",
+				remote.Filename,
+			)
+		}
+
+		if checksum == "" {
+			log.Debugf(
+				nil,
+				"keeping attachment not uploaded by mark: %q",
+				remote.Filename,
+			)
+
+			continue
+		}
+
+		log.Infof(nil, "removing stale attachment: %q", remote.Filename)
+
+		err = api.DeleteAttachment(remote.ID)
+		if err != nil {
+			return nil, karma.Format(
+				err,
+				"unable to remove attachment %q",
+				remote.Filename,
+			)
+		}
+
+		removed = append(removed, remote.Filename)
+	}
+
+	return removed, nil
 }
 
+// CompileAttachmentLinks replaces local paths of attachments in markdown with
+// links to uploaded attachments. References to attachments in storage format
+// (ri:filename attribute, e.g. in ac:image template) are replaced with names
+// of uploaded attachments instead.
 func CompileAttachmentLinks(markdown []byte, attaches []Attachment) []byte {
 	links := map[string]string{}
 	replaces := []string{}
+	positions := map[string]position.Position{}
+
+	// hide references from link replacing, because local path can be the
+	// same as attachment name
+	references := map[string]string{}
+	used := map[string]bool{}
+	for i, attach := range attaches {
+		from := `ri:filename="` + attach.Replace + `"`
+		if !bytes.Contains(markdown, []byte(from)) {
+			continue
+		}
+
+		placeholder := fmt.Sprintf("\x00attachment:%d\x00", i)
+
+		log.Debugf(
+			nil,
+			"replacing attachment reference: %q -> %q",
+			attach.Replace,
+			attach.Filename,
+		)
+
+		markdown = bytes.ReplaceAll(markdown, []byte(from), []byte(placeholder))
+
+		references[placeholder] = `ri:filename="` + attach.Filename + `"`
+		used[attach.Replace] = true
+	}
 
 	for _, attach := range attaches {
 		uri, err := url.ParseRequestURI(attach.Link)
 		if err != nil {
-			links[attach.Replace] = strings.ReplaceAll("&", "&amp;", attach.Link)
+			links[attach.Replace] = strings.ReplaceAll(attach.Link, "&", "&amp;")
 		} else {
 			links[attach.Replace] = uri.Path +
 				"?" + url.QueryEscape(uri.Query().Encode())
 		}
 
 		replaces = append(replaces, attach.Replace)
+		positions[attach.Replace] = attach.Position
 	}
 
 	// sort by length so first items will have bigger length
@@ -189,40 +617,82 @@ func CompileAttachmentLinks(markdown []byte, attaches []Attachment) []byte {
 		to := links[replace]
 
 		found := false
-		if bytes.Contains(markdown, []byte("attachment://"+replace)) {
-			from := "attachment://" + replace
+		for _, from := range []string{"attachment://" + replace, replace} {
+			var ok bool
+
+			markdown, ok = replaceLinkTarget(markdown, from, to)
+			if ok {
+				log.Debugf(nil, "replacing link: %q -> %q", from, to)
 
-			log.Debugf(nil, "replacing legacy link: %q -> %q", from, to)
+				found = true
+			}
+		}
 
-			markdown = bytes.ReplaceAll(
-				markdown,
-				[]byte(from),
-				[]byte(to),
+		if !found && !used[replace] {
+			log.Warningf(
+				nil,
+				"%sunused attachment: %s",
+				positions[replace].Prefix(),
+				replace,
 			)
+		}
+	}
+
+	for placeholder, reference := range references {
+		markdown = bytes.ReplaceAll(
+			markdown,
+			[]byte(placeholder),
+			[]byte(reference),
+		)
+	}
 
-			found = true
+	return markdown
+}
+
+// reLinkTargetPrefix matches what can precede link target: markdown link
+// or image, autolink, reference definition or HTML attribute.
+const reLinkTargetPrefix = `(\(|<|"|'|=|\]:[ \t]*)`
+
+// replaceLinkTarget replaces link targets which are exactly equal to from,
+// so paths which only contain it (like ./a.png for a.png) are kept intact.
+func replaceLinkTarget(markdown []byte, from string, to string) ([]byte, bool) {
+	matcher := regexp.MustCompile(reLinkTargetPrefix + regexp.QuoteMeta(from))
+
+	var (
+		result   []byte
+		last     int
+		replaced bool
+	)
+
+	for _, match := range matcher.FindAllSubmatchIndex(markdown, -1) {
+		end := match[1]
+		if end < len(markdown) &&
+			!bytes.ContainsAny(markdown[end:end+1], ")>\"' \t\r\n") {
+			continue
 		}
 
-		if bytes.Contains(markdown, []byte(replace)) {
-			from := replace
+		result = append(result, markdown[last:match[3]]...)
+		result = append(result, to...)
 
-			log.Debugf(nil, "replacing link: %q -> %q", from, to)
+		last = end
+		replaced = true
+	}
 
-			markdown = bytes.ReplaceAll(
-				markdown,
-				[]byte(from),
-				[]byte(to),
-			)
+	if !replaced {
+		return markdown, false
+	}
 
-			found = true
-		}
+	return append(result, markdown[last:]...), true
+}
 
-		if !found {
-			log.Warningf(nil, "unused attachment: %s", replace)
+func hasAttachment(remotes []confluence.AttachmentInfo, filename string) bool {
+	for _, remote := range remotes {
+		if remote.Filename == filename {
+			return true
 		}
 	}
 
-	return markdown
+	return false
 }
 
 func getChecksum(filename string) (string, error) {
@@ -242,3 +712,51 @@ func getChecksum(filename string) (string, error) {
 
 	return hex.EncodeToString(hash.Sum(nil)), nil
 }
+
+// getRemoteChecksum returns checksum of file uploaded by mark or empty
+// string if attachment was uploaded by someone else.
+func getRemoteChecksum(
+	api confluence.Client,
+	remote confluence.AttachmentInfo,
+) (string, error) {
+	property, err := api.GetContentProperty(remote.ID, PropertyChecksum)
+	if err != nil {
+		return "", err
+	}
+
+	if property != nil {
+		return fmt.Sprint(property.Value), nil
+	}
+
+	if strings.HasPrefix(remote.Metadata.Comment, AttachmentChecksumPrefix) {
+		return strings.TrimPrefix(
+			remote.Metadata.Comment,
+			AttachmentChecksumPrefix,
+		), nil
+	}
+
+	return "", nil
+}
+
+func getAttachmentComment(path string, comment string) string {
+	if comment != "" {
+		return comment
+	}
+
+	cmd := exec.Command(
+		"git", "log", "-1", "--format=%s", "--", filepath.Base(path),
+	)
+	cmd.Dir = filepath.Dir(path)
+
+	output, err := cmd.Output()
+	if err != nil {
+		log.Debugf(
+			karma.Describe("path", path).Describe("error", err),
+			"unable to obtain git commit subject for attachment comment",
+		)
+
+		return ""
+	}
+
+	return strings.TrimSpace(string(output))
+}
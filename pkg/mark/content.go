@@ -0,0 +1,120 @@
+package mark
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kovetskiy/mark/pkg/confluence"
+	"github.com/reconquest/karma-go"
+)
+
+// PropertyContent is a content property of page which holds checksum of
+// content published by mark along with version of the page it produced, so
+// unchanged content is not published again unless page was edited manually.
+const PropertyContent = `mark-content`
+
+// IsContentChanged checks whether given page content (along with page title
+// and location) differs from the content which was published last time, or
+// page was changed by someone else since then.
+func IsContentChanged(
+	api confluence.Client,
+	page *confluence.PageInfo,
+	html string,
+) (bool, error) {
+	property, err := api.GetContentProperty(page.ID, PropertyContent)
+	if err != nil {
+		return false, karma.Format(
+			err,
+			"unable to obtain content checksum of page %q",
+			page.Title,
+		)
+	}
+
+	if property == nil {
+		return true, nil
+	}
+
+	return fmt.Sprint(property.Value) != getContentChecksum(page, html), nil
+}
+
+// SetContentChecksum stores checksum of published content in the page, page
+// should have version which was produced by publishing given content.
+func SetContentChecksum(
+	api confluence.Client,
+	page *confluence.PageInfo,
+	html string,
+) error {
+	err := api.SetContentProperty(
+		page.ID,
+		PropertyContent,
+		getContentChecksum(page, html),
+	)
+	if err != nil {
+		return karma.Format(
+			err,
+			"unable to set content checksum of page %q",
+			page.Title,
+		)
+	}
+
+	return nil
+}
+
+// GetPublishedVersion returns version of the page which was produced by the
+// last publication of mark, or zero if page was never published by mark.
+func GetPublishedVersion(
+	api confluence.Client,
+	page *confluence.PageInfo,
+) (int64, error) {
+	property, err := api.GetContentProperty(page.ID, PropertyContent)
+	if err != nil {
+		return 0, karma.Format(
+			err,
+			"unable to obtain content checksum of page %q",
+			page.Title,
+		)
+	}
+
+	if property == nil {
+		return 0, nil
+	}
+
+	value := fmt.Sprint(property.Value)
+
+	version, err := strconv.ParseInt(
+		value[:strings.Index(value+":", ":")],
+		10,
+		64,
+	)
+	if err != nil {
+		return 0, karma.Format(
+			err,
+			"invalid content checksum of page %q: %q",
+			page.Title,
+			value,
+		)
+	}
+
+	return version, nil
+}
+
+func getContentChecksum(page *confluence.PageInfo, html string) string {
+	hash := sha256.New()
+
+	fmt.Fprintln(hash, page.Title)
+
+	if len(page.Ancestors) > 0 {
+		fmt.Fprintln(hash, page.Ancestors[len(page.Ancestors)-1].Id)
+	}
+
+	fmt.Fprint(hash, html)
+
+	return fmt.Sprintf(
+		"%d:%s",
+		page.Version.Number,
+		hex.EncodeToString(hash.Sum(nil)),
+	)
+}
@@ -12,6 +12,7 @@ import (
 	"gopkg.in/yaml.v2"
 
 	"github.com/kovetskiy/mark/pkg/log"
+	"github.com/kovetskiy/mark/pkg/mark/position"
 	"github.com/reconquest/karma-go"
 )
 
@@ -64,8 +65,23 @@ func LoadTemplate(
 	return templates, nil
 }
 
+// FindIncludes returns paths of templates which are included by Include
+// directives in the contents.
+func FindIncludes(contents []byte) []string {
+	paths := []string{}
+	for _, groups := range reIncludeDirective.FindAllSubmatch(contents, -1) {
+		paths = append(paths, string(groups[1]))
+	}
+
+	return paths
+}
+
+// ProcessIncludes expands Include directives in the contents. Given lines map
+// is updated to match expanded contents and is used to prefix errors with
+// position of the directive; it can be nil.
 func ProcessIncludes(
 	contents []byte,
+	lines *position.Map,
 	templates *template.Template,
 ) (*template.Template, []byte, bool, error) {
 	vardump := func(
@@ -89,16 +105,15 @@ func ProcessIncludes(
 
 	var (
 		recurse bool
-		err     error
+		errs    []error
 	)
 
-	contents = reIncludeDirective.ReplaceAllFunc(
+	// failed directives are removed, so the rest of them are checked and
+	// all problems are reported at once
+	contents = lines.ReplaceAllFunc(
+		reIncludeDirective,
 		contents,
-		func(spec []byte) []byte {
-			if err != nil {
-				return nil
-			}
-
+		func(spec []byte, at position.Position) []byte {
 			groups := reIncludeDirective.FindSubmatch(spec)
 
 			var (
@@ -108,35 +123,43 @@ func ProcessIncludes(
 				facts = karma.Describe("path", path)
 			)
 
-			err = yaml.Unmarshal(config, &data)
+			err := yaml.Unmarshal(config, &data)
 			if err != nil {
-				err = facts.
+				errs = append(errs, facts.
 					Describe("config", string(config)).
 					Format(
 						err,
-						"unable to unmarshal template data config",
-					)
+						"%sunable to unmarshal template data config",
+						at.Prefix(),
+					))
 
 				return nil
 			}
 
 			log.Tracef(vardump(facts, data), "including template %q", path)
 
-			templates, err = LoadTemplate(path, templates)
+			loaded, err := LoadTemplate(path, templates)
 			if err != nil {
-				err = facts.Format(err, "unable to load template")
+				errs = append(errs, facts.Format(
+					err,
+					"%sunable to load template",
+					at.Prefix(),
+				))
 
 				return nil
 			}
 
+			templates = loaded
+
 			var buffer bytes.Buffer
 
 			err = templates.Execute(&buffer, data)
 			if err != nil {
-				err = vardump(facts, data).Format(
+				errs = append(errs, vardump(facts, data).Format(
 					err,
-					"unable to execute template",
-				)
+					"%sunable to execute template",
+					at.Prefix(),
+				))
 
 				return nil
 			}
@@ -147,5 +170,8 @@ func ProcessIncludes(
 		},
 	)
 
-	return templates, contents, recurse, err
+	return templates, contents, recurse, position.JoinErrors(
+		fmt.Sprintf("%d includes failed", len(errs)),
+		errs,
+	)
 }
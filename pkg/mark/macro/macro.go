@@ -9,6 +9,7 @@ import (
 
 	"github.com/kovetskiy/mark/pkg/log"
 	"github.com/kovetskiy/mark/pkg/mark/includes"
+	"github.com/kovetskiy/mark/pkg/mark/position"
 	"github.com/reconquest/karma-go"
 	"github.com/reconquest/regexputil-go"
 	"gopkg.in/yaml.v2"
@@ -29,24 +30,34 @@ type Macro struct {
 	Regexp   *regexp.Regexp
 	Template *template.Template
 	Config   string
+
+	// Position is a position of Macro directive, it is empty for macros
+	// which are not declared in the file.
+	Position position.Position
 }
 
+// Apply replaces matches of the macro in the content with executed template.
+// Given lines map is updated to match the result and is used to prefix errors
+// with position of the match; it can be nil.
 func (macro *Macro) Apply(
 	content []byte,
+	lines *position.Map,
 ) ([]byte, error) {
-	var err error
+	var errs []error
 
-	content = macro.Regexp.ReplaceAllFunc(
+	content = lines.ReplaceAllFunc(
+		macro.Regexp,
 		content,
-		func(match []byte) []byte {
+		func(match []byte, at position.Position) []byte {
 			config := map[string]interface{}{}
 
-			err = yaml.Unmarshal([]byte(macro.Config), &config)
+			err := yaml.Unmarshal([]byte(macro.Config), &config)
 			if err != nil {
-				err = karma.Format(
+				errs = append(errs, karma.Format(
 					err,
-					"unable to unmarshal macros config template",
-				)
+					"%sunable to unmarshal macros config template",
+					macro.Position.Prefix(),
+				))
 			}
 
 			var buffer bytes.Buffer
@@ -56,17 +67,21 @@ func (macro *Macro) Apply(
 				macro.Regexp.FindSubmatch(match),
 			))
 			if err != nil {
-				err = karma.Format(
+				errs = append(errs, karma.Format(
 					err,
-					"unable to execute macros template",
-				)
+					"%sunable to execute macros template",
+					at.Prefix(),
+				))
 			}
 
 			return buffer.Bytes()
 		},
 	)
 
-	return content, err
+	return content, position.JoinErrors(
+		fmt.Sprintf("%d macros failed", len(errs)),
+		errs,
+	)
 }
 
 func (macro *Macro) configure(node interface{}, groups [][]byte) interface{} {
@@ -104,21 +119,42 @@ func (macro *Macro) configure(node interface{}, groups [][]byte) interface{} {
 	return node
 }
 
+// FindTemplates returns paths of templates which are used by Macro directives
+// in the contents.
+func FindTemplates(contents []byte) []string {
+	paths := []string{}
+	for _, groups := range reMacroDirective.FindAllStringSubmatch(
+		string(contents),
+		-1,
+	) {
+		paths = append(
+			paths,
+			regexputil.Subexp(reMacroDirective, groups, "template"),
+		)
+	}
+
+	return paths
+}
+
+// ExtractMacros removes Macro directives from the contents and returns
+// declared macros. Given lines map is updated to match the result and is used
+// to prefix errors with position of the directive; it can be nil.
 func ExtractMacros(
 	contents []byte,
+	lines *position.Map,
 	templates *template.Template,
 ) ([]Macro, []byte, error) {
-	var err error
-
-	var macros []Macro
+	var (
+		macros []Macro
+		errs   []error
+	)
 
-	contents = reMacroDirective.ReplaceAllFunc(
+	// failed directives are removed, so the rest of them are checked and
+	// all problems are reported at once
+	contents = lines.ReplaceAllFunc(
+		reMacroDirective,
 		contents,
-		func(spec []byte) []byte {
-			if err != nil {
-				return spec
-			}
-
+		func(spec []byte, at position.Position) []byte {
 			groups := reMacroDirective.FindStringSubmatch(string(spec))
 
 			var (
@@ -126,13 +162,18 @@ func ExtractMacros(
 				template = regexputil.Subexp(reMacroDirective, groups, "template")
 				config   = regexputil.Subexp(reMacroDirective, groups, "config")
 
-				macro Macro
+				macro = Macro{Position: at}
 			)
 
-			macro.Template, err = includes.LoadTemplate(template, templates)
+			var err error
 
+			macro.Template, err = includes.LoadTemplate(template, templates)
 			if err != nil {
-				err = karma.Format(err, "unable to load template")
+				errs = append(errs, karma.Format(
+					err,
+					"%sunable to load template",
+					at.Prefix(),
+				))
 
 				return nil
 			}
@@ -143,11 +184,12 @@ func ExtractMacros(
 
 			macro.Regexp, err = regexp.Compile(expr)
 			if err != nil {
-				err = facts.
+				errs = append(errs, facts.
 					Format(
 						err,
-						"unable to compile macros regexp",
-					)
+						"%sunable to compile macros regexp",
+						at.Prefix(),
+					))
 
 				return nil
 			}
@@ -166,5 +208,8 @@ func ExtractMacros(
 		},
 	)
 
-	return macros, contents, err
+	return macros, contents, position.JoinErrors(
+		fmt.Sprintf("%d macros failed", len(errs)),
+		errs,
+	)
 }
@@ -1,25 +1,77 @@
 package mark
 
 import (
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/kovetskiy/mark/pkg/confluence"
 	"github.com/kovetskiy/mark/pkg/log"
 	"github.com/reconquest/karma-go"
 )
 
+// ResolvePage returns parent page and the page itself (nil if it doesn't
+// exist yet) described by given metadata. For blog posts and home page of
+// the space parent is always nil.
 func ResolvePage(
 	dryRun bool,
-	api *confluence.API,
+	api confluence.Client,
 	meta *Meta,
 ) (*confluence.PageInfo, *confluence.PageInfo, error) {
-	page, err := api.FindPage(meta.Space, meta.Title)
-	if err != nil {
-		return nil, nil, karma.Format(
-			err,
-			"error while finding page %q",
-			meta.Title,
-		)
+	if meta.Type == confluence.ContentTypeBlogPost {
+		post, err := resolveBlogPost(api, meta)
+
+		return nil, post, err
+	}
+
+	var (
+		page *confluence.PageInfo
+		err  error
+
+		// owned page is known to be published from the same source file,
+		// so it can be renamed or moved instead of creating a new one
+		owned bool
+	)
+
+	if meta.ID != "" {
+		page, err = api.GetPageByID(meta.ID)
+		if err != nil {
+			return nil, nil, karma.Format(
+				err,
+				"error while getting page by id %q",
+				meta.ID,
+			)
+		}
+
+		owned = true
+	} else {
+		page, err = api.FindPage(meta.Space, meta.Title)
+		if err != nil {
+			return nil, nil, karma.Format(
+				err,
+				"error while finding page %q",
+				meta.Title,
+			)
+		}
+	}
+
+	if meta.Source != "" && !owned {
+		page, owned, err = resolveSourcePage(api, meta, page)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// home page of the space is the only page without parents, it can't
+	// be moved, so it's updated in place
+	if page != nil && len(page.Ancestors) == 0 && len(meta.Parents) == 0 {
+		log.Infof(nil, "page %q is a home page of the space", page.Title)
+
+		if owned {
+			relocatePage(page, nil, meta.Title)
+		}
+
+		return nil, page, nil
 	}
 
 	ancestry := meta.Parents
@@ -27,7 +79,7 @@ func ResolvePage(
 		ancestry = append(ancestry, page.Title)
 	}
 
-	if len(ancestry) > 0 {
+	if len(ancestry) > 0 && !owned {
 		page, err := ValidateAncestry(
 			api,
 			meta.Space,
@@ -69,6 +121,10 @@ func ResolvePage(
 		)
 	}
 
+	if owned {
+		relocatePage(page, parent, meta.Title)
+	}
+
 	titles := []string{}
 	for _, page := range parent.Ancestors {
 		titles = append(titles, page.Title)
@@ -85,3 +141,102 @@ func ResolvePage(
 
 	return parent, page, nil
 }
+
+// relocatePage changes title and parent of given page, so following
+// UpdatePage call will rename and move it while keeping its history.
+func relocatePage(
+	page *confluence.PageInfo,
+	parent *confluence.PageInfo,
+	title string,
+) {
+	if page.Title != title {
+		log.Infof(nil, "page %q will be renamed to %q", page.Title, title)
+
+		page.Title = title
+	}
+
+	// blog posts and home page have no parents
+	if parent == nil {
+		return
+	}
+
+	if len(page.Ancestors) > 0 &&
+		page.Ancestors[len(page.Ancestors)-1].Id == parent.ID {
+		return
+	}
+
+	ancestors := append([]confluence.PageAncestor{}, parent.Ancestors...)
+	ancestors = append(ancestors, confluence.PageAncestor{
+		Id:    parent.ID,
+		Title: parent.Title,
+	})
+
+	log.Infof(
+		nil,
+		"page %q will be moved: %s -> %s",
+		title,
+		getAncestryPath(page.Ancestors),
+		getAncestryPath(ancestors),
+	)
+
+	page.Ancestors = ancestors
+}
+
+func resolveBlogPost(
+	api confluence.Client,
+	meta *Meta,
+) (*confluence.PageInfo, error) {
+	if meta.ID != "" {
+		post, err := api.GetPageByID(meta.ID)
+		if err != nil {
+			return nil, karma.Format(
+				err,
+				"error while getting blog post by id %q",
+				meta.ID,
+			)
+		}
+
+		relocatePage(post, nil, meta.Title)
+
+		return post, nil
+	}
+
+	post, err := api.FindBlogPost(meta.Space, meta.Title, meta.Date)
+	if err != nil {
+		return nil, karma.Format(
+			err,
+			"error while finding blog post %q",
+			meta.Title,
+		)
+	}
+
+	if post == nil && meta.Source != "" {
+		post, _, err = resolveSourcePage(api, meta, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if post != nil {
+			relocatePage(post, nil, meta.Title)
+		}
+	}
+
+	if post == nil {
+		// Confluence API has no way to set posting day of blog post, new
+		// blog post is always posted today
+		if meta.Date != "" && meta.Date != time.Now().Format("2006-01-02") {
+			return nil, fmt.Errorf(
+				"blog post %q posted on %s is not found and can't be created: "+
+					"%s header is used only to find existing blog post, "+
+					"new blog posts are posted today",
+				meta.Title,
+				meta.Date,
+				HeaderDate,
+			)
+		}
+
+		log.Infof(nil, "blog post %q will be created", meta.Title)
+	}
+
+	return post, nil
+}
@@ -0,0 +1,208 @@
+package merge
+
+import (
+	"strings"
+)
+
+// Conflict markers which surround conflicting changes, the same as used by
+// git with diff3 conflict style.
+const (
+	MarkerOurs   = `<<<<<<<`
+	MarkerBase   = `|||||||`
+	MarkerSplit  = `=======`
+	MarkerTheirs = `>>>>>>>`
+)
+
+// maxMatchCells limits size of the table which is used to find the longest
+// common subsequence of lines, since it takes O(n*m) memory: 4M cells are
+// enough for 2000 changed lines in both texts after common leading and
+// trailing lines are skipped. Lines of larger texts are considered changed
+// as a whole, so changes made in both texts are reported as a conflict
+// rather than merged.
+const maxMatchCells = 4 * 1024 * 1024
+
+// Labels are names of merged texts which are written after conflict markers.
+type Labels struct {
+	Ours   string
+	Base   string
+	Theirs string
+}
+
+// Merge merges changes made in ours and theirs texts since base text line by
+// line. Lines which were changed differently in both texts are written
+// surrounded by conflict markers. It returns merged text and number of
+// conflicts.
+func Merge(base, ours, theirs string, labels Labels) (string, int) {
+	var (
+		baseLines   = split(base)
+		oursLines   = split(ours)
+		theirsLines = split(theirs)
+
+		matchOurs   = match(baseLines, oursLines)
+		matchTheirs = match(baseLines, theirsLines)
+
+		merged    = []string{}
+		conflicts = 0
+	)
+
+	// stable lines are base lines which are kept in both texts, changes
+	// between them are resolved as a whole
+	for b, o, t := 0, 0, 0; ; {
+		next := b
+		for next < len(baseLines) &&
+			(matchOurs[next] < 0 || matchTheirs[next] < 0) {
+			next++
+		}
+
+		nextOurs, nextTheirs := len(oursLines), len(theirsLines)
+		if next < len(baseLines) {
+			nextOurs, nextTheirs = matchOurs[next], matchTheirs[next]
+		}
+
+		var (
+			chunkBase   = baseLines[b:next]
+			chunkOurs   = oursLines[o:nextOurs]
+			chunkTheirs = theirsLines[t:nextTheirs]
+		)
+
+		switch {
+		case equal(chunkOurs, chunkBase):
+			merged = append(merged, chunkTheirs...)
+		case equal(chunkTheirs, chunkBase), equal(chunkOurs, chunkTheirs):
+			merged = append(merged, chunkOurs...)
+		default:
+			conflicts++
+
+			merged = append(merged, MarkerOurs+" "+labels.Ours)
+			merged = append(merged, chunkOurs...)
+			merged = append(merged, MarkerBase+" "+labels.Base)
+			merged = append(merged, chunkBase...)
+			merged = append(merged, MarkerSplit)
+			merged = append(merged, chunkTheirs...)
+			merged = append(merged, MarkerTheirs+" "+labels.Theirs)
+		}
+
+		if next == len(baseLines) {
+			break
+		}
+
+		merged = append(merged, baseLines[next])
+
+		b, o, t = next+1, nextOurs+1, nextTheirs+1
+	}
+
+	if len(merged) == 0 {
+		return "", conflicts
+	}
+
+	return strings.Join(merged, "\n") + "\n", conflicts
+}
+
+func split(text string) []string {
+	if text == "" {
+		return []string{}
+	}
+
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// match finds the longest common subsequence of base and changed lines and
+// returns index of matching changed line for every base line, or -1 if the
+// base line is removed or modified. Common leading and trailing lines are
+// matched as is, so the table of lengths is built for changed lines only.
+func match(base, changed []string) []int {
+	matches := make([]int, len(base))
+
+	prefix := 0
+	for prefix < len(base) && prefix < len(changed) &&
+		base[prefix] == changed[prefix] {
+		matches[prefix] = prefix
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(base)-prefix && suffix < len(changed)-prefix &&
+		base[len(base)-1-suffix] == changed[len(changed)-1-suffix] {
+		matches[len(base)-1-suffix] = len(changed) - 1 - suffix
+		suffix++
+	}
+
+	middle := matchLines(
+		base[prefix:len(base)-suffix],
+		changed[prefix:len(changed)-suffix],
+	)
+
+	for i, j := range middle {
+		if j >= 0 {
+			j += prefix
+		}
+
+		matches[prefix+i] = j
+	}
+
+	return matches
+}
+
+// matchLines is match which builds the table of lengths for all given lines.
+// No lines are matched if the table is larger than maxMatchCells.
+func matchLines(base, changed []string) []int {
+	matches := make([]int, len(base))
+
+	if (len(base)+1)*(len(changed)+1) > maxMatchCells {
+		for i := range matches {
+			matches[i] = -1
+		}
+
+		return matches
+	}
+
+	// lengths[i][j] is a length of the longest common subsequence of
+	// base[i:] and changed[j:]
+	lengths := make([][]int, len(base)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(changed)+1)
+	}
+
+	for i := len(base) - 1; i >= 0; i-- {
+		for j := len(changed) - 1; j >= 0; j-- {
+			switch {
+			case base[i] == changed[j]:
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			case lengths[i+1][j] >= lengths[i][j+1]:
+				lengths[i][j] = lengths[i+1][j]
+			default:
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+
+	for i, j := 0, 0; i < len(base); {
+		switch {
+		case j < len(changed) && base[i] == changed[j]:
+			matches[i] = j
+			i++
+			j++
+		case j < len(changed) && lengths[i][j+1] > lengths[i+1][j]:
+			j++
+		default:
+			matches[i] = -1
+			i++
+		}
+	}
+
+	return matches
+}
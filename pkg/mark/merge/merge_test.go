@@ -0,0 +1,202 @@
+package merge
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var labels = Labels{Ours: "local", Base: "published", Theirs: "confluence"}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name      string
+		base      string
+		ours      string
+		theirs    string
+		expected  string
+		conflicts int
+	}{
+		{
+			name:     "no changes",
+			base:     "a\nb\nc\n",
+			ours:     "a\nb\nc\n",
+			theirs:   "a\nb\nc\n",
+			expected: "a\nb\nc\n",
+		},
+		{
+			name:     "changed in ours",
+			base:     "a\nb\nc\n",
+			ours:     "a\nB\nc\n",
+			theirs:   "a\nb\nc\n",
+			expected: "a\nB\nc\n",
+		},
+		{
+			name:     "changed in theirs",
+			base:     "a\nb\nc\n",
+			ours:     "a\nb\nc\n",
+			theirs:   "a\nb\nC\n",
+			expected: "a\nb\nC\n",
+		},
+		{
+			name:     "different lines changed",
+			base:     "a\nb\nc\nd\n",
+			ours:     "A\nb\nc\nd\n",
+			theirs:   "a\nb\nc\nD\n",
+			expected: "A\nb\nc\nD\n",
+		},
+		{
+			name:     "same change",
+			base:     "a\nb\nc\n",
+			ours:     "a\nB\nc\n",
+			theirs:   "a\nB\nc\n",
+			expected: "a\nB\nc\n",
+		},
+		{
+			name:     "lines added and removed",
+			base:     "a\nb\nc\nd\n",
+			ours:     "a\nx\nb\nc\nd\n",
+			theirs:   "a\nb\nd\n",
+			expected: "a\nx\nb\nd\n",
+		},
+		{
+			name:   "conflict",
+			base:   "a\nb\nc\n",
+			ours:   "a\nB\nc\n",
+			theirs: "a\nX\nc\n",
+			expected: "a\n" +
+				"<<<<<<< local\nB\n" +
+				"||||||| published\nb\n" +
+				"=======\nX\n" +
+				">>>>>>> confluence\n" +
+				"c\n",
+			conflicts: 1,
+		},
+		{
+			name:   "conflict and clean change",
+			base:   "a\nb\nc\nd\n",
+			ours:   "a\nB\nc\nd\n",
+			theirs: "a\nX\nc\nD\n",
+			expected: "a\n" +
+				"<<<<<<< local\nB\n" +
+				"||||||| published\nb\n" +
+				"=======\nX\n" +
+				">>>>>>> confluence\n" +
+				"c\nD\n",
+			conflicts: 1,
+		},
+		{
+			name:   "removed and changed",
+			base:   "a\nb\nc\n",
+			ours:   "a\nc\n",
+			theirs: "a\nX\nc\n",
+			expected: "a\n" +
+				"<<<<<<< local\n" +
+				"||||||| published\nb\n" +
+				"=======\nX\n" +
+				">>>>>>> confluence\n" +
+				"c\n",
+			conflicts: 1,
+		},
+		{
+			name:     "empty base and ours",
+			base:     "",
+			ours:     "",
+			theirs:   "a\n",
+			expected: "a\n",
+		},
+		{
+			name:     "empty base and same text",
+			base:     "",
+			ours:     "a\n",
+			theirs:   "a\n",
+			expected: "a\n",
+		},
+		{
+			name:   "empty base and different texts",
+			base:   "",
+			ours:   "a\n",
+			theirs: "b\n",
+			expected: "<<<<<<< local\na\n" +
+				"||||||| published\n" +
+				"=======\nb\n" +
+				">>>>>>> confluence\n",
+			conflicts: 1,
+		},
+		{
+			name:     "everything removed",
+			base:     "a\nb\n",
+			ours:     "",
+			theirs:   "a\nb\n",
+			expected: "",
+		},
+		{
+			name:     "no trailing newline",
+			base:     "a\nb",
+			ours:     "a\nb",
+			theirs:   "a\nB",
+			expected: "a\nB\n",
+		},
+	}
+
+	for _, test := range tests {
+		merged, conflicts := Merge(test.base, test.ours, test.theirs, labels)
+
+		if merged != test.expected {
+			t.Errorf(
+				"%s: unexpected merge result:\n%s\nexpected:\n%s",
+				test.name,
+				merged,
+				test.expected,
+			)
+		}
+
+		if conflicts != test.conflicts {
+			t.Errorf(
+				"%s: expected %d conflicts, got %d",
+				test.name,
+				test.conflicts,
+				conflicts,
+			)
+		}
+	}
+}
+
+func TestMerge_Large(t *testing.T) {
+	lines := []string{}
+	for i := 0; i < 3000; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+
+	change := func(indexes ...int) string {
+		changed := append([]string{}, lines...)
+		for _, index := range indexes {
+			changed[index] = "changed"
+		}
+
+		return strings.Join(changed, "\n") + "\n"
+	}
+
+	base := change()
+
+	// only common leading and trailing lines are skipped, changed lines
+	// in between are too many to be matched, but they are not changed in
+	// theirs text
+	merged, conflicts := Merge(base, change(1, 2998), base, labels)
+	if merged != change(1, 2998) || conflicts != 0 {
+		t.Fatalf("expected changes to be merged, got %d conflicts", conflicts)
+	}
+
+	// changes in both texts are reported as a single conflict instead of
+	// being merged line by line
+	merged, conflicts = Merge(base, change(1, 2998), change(1500), labels)
+	if conflicts != 1 || !strings.Contains(merged, MarkerOurs) {
+		t.Fatalf("expected single conflict, got %d conflicts", conflicts)
+	}
+
+	// small change is merged regardless of size of the text
+	merged, conflicts = Merge(base, change(10), change(20), labels)
+	if merged != change(10, 20) || conflicts != 0 {
+		t.Fatalf("expected changes to be merged, got %d conflicts", conflicts)
+	}
+}
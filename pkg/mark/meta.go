@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
+	"github.com/kovetskiy/mark/pkg/confluence"
 	"github.com/kovetskiy/mark/pkg/log"
+	"github.com/kovetskiy/mark/pkg/mark/position"
 )
 
 const (
@@ -16,14 +19,57 @@ const (
 	HeaderTitle      = `Title`
 	HeaderLayout     = `Layout`
 	HeaderAttachment = `Attachment`
+	HeaderID         = `ID`
+	HeaderType       = `Type`
+	HeaderDate       = `Date`
+	HeaderAppearance = `Appearance`
+	HeaderEmoji      = `Emoji`
+	HeaderCover      = `Cover`
+
+	HeaderAttachmentComment = `Attachment-Comment`
 )
 
 type Meta struct {
-	Parents     []string
-	Space       string
-	Title       string
-	Layout      string
-	Attachments map[string]string
+	ID      string
+	Parents []string
+	Space   string
+	Title   string
+	Layout  string
+
+	// Attachments are values of Attachment headers as is, see
+	// ExpandAttachments for supported syntax.
+	Attachments []Header
+
+	// AttachmentComment is a comment which is set to uploaded attachments.
+	AttachmentComment string
+
+	// Type is either confluence.ContentTypePage (default) or
+	// confluence.ContentTypeBlogPost.
+	Type string
+
+	// Date is a posting day of blog post in YYYY-MM-DD format.
+	Date string
+
+	// Appearance, Emoji and Cover are applied to page as content
+	// properties, see SetPageProperties.
+	Appearance string
+	Emoji      string
+	Cover      string
+
+	// Source is a path of markdown file which is used to identify page
+	// after it was renamed or moved, see GetSourcePath. It is not read from
+	// headers and should be set by caller.
+	Source string
+
+	// File is a path of markdown file on disk, which is used to find
+	// previous source paths of the file in git history.
+	File string
+}
+
+// Header is a value of the header along with its position in the file.
+type Header struct {
+	Value    string
+	Position position.Position
 }
 
 var (
@@ -31,10 +77,27 @@ var (
 	reHeaderPatternV2 = regexp.MustCompile(`<!--\s*([^:]+):\s*(.*)\s*-->`)
 )
 
-func ExtractMeta(data []byte) (*Meta, []byte, error) {
+// ExtractMeta reads headers from the beginning of the file and returns the
+// rest of the file. Errors and warnings about headers are prefixed with
+// position of the header in the given file. If headers are invalid, the rest
+// of the file is returned along with the error.
+func ExtractMeta(file string, data []byte) (*Meta, []byte, error) {
+	return extractMeta(file, data, log.Warningf)
+}
+
+// extractMeta is ExtractMeta which reports warnings using given function.
+func extractMeta(
+	file string,
+	data []byte,
+	warningf func(reason error, message string, args ...interface{}),
+) (*Meta, []byte, error) {
 	var (
 		meta   *Meta
 		offset int
+		number int
+
+		// positions contains position of the first occurrence of headers
+		positions = map[string]position.Position{}
 	)
 
 	scanner := bufio.NewScanner(bytes.NewBuffer(data))
@@ -46,6 +109,9 @@ func ExtractMeta(data []byte) (*Meta, []byte, error) {
 		}
 
 		offset += len(line) + 1
+		number++
+
+		at := position.Position{File: file, Line: number}
 
 		matches := reHeaderPatternV2.FindStringSubmatch(line)
 		if matches == nil {
@@ -54,9 +120,10 @@ func ExtractMeta(data []byte) (*Meta, []byte, error) {
 				break
 			}
 
-			log.Warningf(
+			warningf(
 				fmt.Errorf(`legacy header usage found: %s`, line),
-				"please use new header format: <!-- %s: %s -->",
+				"%splease use new header format: <!-- %s: %s -->",
+				at.Prefix(),
 				matches[1],
 				matches[2],
 			)
@@ -64,7 +131,6 @@ func ExtractMeta(data []byte) (*Meta, []byte, error) {
 
 		if meta == nil {
 			meta = &Meta{}
-			meta.Attachments = make(map[string]string)
 		}
 
 		header := strings.Title(matches[1])
@@ -74,6 +140,10 @@ func ExtractMeta(data []byte) (*Meta, []byte, error) {
 			value = strings.TrimSpace(matches[2])
 		}
 
+		if _, ok := positions[header]; !ok {
+			positions[header] = at
+		}
+
 		switch header {
 		case HeaderParent:
 			meta.Parents = append(meta.Parents, value)
@@ -88,12 +158,37 @@ func ExtractMeta(data []byte) (*Meta, []byte, error) {
 			meta.Layout = strings.TrimSpace(value)
 
 		case HeaderAttachment:
-			meta.Attachments[value] = value
+			meta.Attachments = append(meta.Attachments, Header{
+				Value:    value,
+				Position: at,
+			})
+
+		case HeaderAttachmentComment:
+			meta.AttachmentComment = value
+
+		case HeaderID:
+			meta.ID = strings.TrimSpace(value)
+
+		case HeaderType:
+			meta.Type = strings.ToLower(strings.TrimSpace(value))
+
+		case HeaderDate:
+			meta.Date = strings.TrimSpace(value)
+
+		case HeaderAppearance:
+			meta.Appearance = strings.ToLower(strings.TrimSpace(value))
+
+		case HeaderEmoji:
+			meta.Emoji = strings.TrimSpace(value)
+
+		case HeaderCover:
+			meta.Cover = strings.TrimSpace(value)
 
 		default:
-			log.Errorf(
+			warningf(
 				nil,
-				`encountered unknown header %q line: %#v`,
+				`%sencountered unknown header %q line: %#v`,
+				at.Prefix(),
 				header,
 				line,
 			)
@@ -106,19 +201,131 @@ func ExtractMeta(data []byte) (*Meta, []byte, error) {
 		return nil, data, nil
 	}
 
+	// all problems of headers are reported at once
+	problems := []error{}
+
+	// missing headers are reported at the beginning of the header block
+	block := position.Position{File: file, Line: 1}
+
 	if meta.Space == "" {
-		return nil, nil, fmt.Errorf(
-			"space key is not set (%s header is not set)",
+		problems = append(problems, fmt.Errorf(
+			"%sspace key is not set (%s header is not set)",
+			block.Prefix(),
 			HeaderSpace,
-		)
+		))
 	}
 
 	if meta.Title == "" {
-		return nil, nil, fmt.Errorf(
-			"page title is not set (%s header is not set)",
+		problems = append(problems, fmt.Errorf(
+			"%spage title is not set (%s header is not set)",
+			block.Prefix(),
 			HeaderTitle,
+		))
+	}
+
+	switch meta.Type {
+	case "":
+		meta.Type = confluence.ContentTypePage
+
+	case confluence.ContentTypePage, confluence.ContentTypeBlogPost:
+
+	default:
+		problems = append(problems, fmt.Errorf(
+			"%sunsupported content type %q (%s header), expected %q or %q",
+			positions[HeaderType].Prefix(),
+			meta.Type,
+			HeaderType,
+			confluence.ContentTypePage,
+			confluence.ContentTypeBlogPost,
+		))
+	}
+
+	if meta.Date != "" {
+		if meta.Type != confluence.ContentTypeBlogPost {
+			problems = append(problems, fmt.Errorf(
+				"%s%s header can be used only with %s: %s",
+				positions[HeaderDate].Prefix(),
+				HeaderDate,
+				HeaderType,
+				confluence.ContentTypeBlogPost,
+			))
+		} else if _, err := time.Parse("2006-01-02", meta.Date); err != nil {
+			problems = append(problems, fmt.Errorf(
+				"%sinvalid %s header %q, expected YYYY-MM-DD format",
+				positions[HeaderDate].Prefix(),
+				HeaderDate,
+				meta.Date,
+			))
+		}
+	}
+
+	switch meta.Appearance {
+	case "", AppearanceFullWidth, AppearanceFixed:
+
+	default:
+		problems = append(problems, fmt.Errorf(
+			"%sunsupported appearance %q (%s header), expected %q or %q",
+			positions[HeaderAppearance].Prefix(),
+			meta.Appearance,
+			HeaderAppearance,
+			AppearanceFullWidth,
+			AppearanceFixed,
+		))
+	}
+
+	// contents are returned along with the error, so they can be checked
+	// as well
+	err := position.JoinErrors(
+		fmt.Sprintf("%d headers are invalid", len(problems)),
+		problems,
+	)
+	if err != nil {
+		return nil, data[offset:], err
+	}
+
+	if meta.Type == confluence.ContentTypeBlogPost && len(meta.Parents) > 0 {
+		warningf(
+			nil,
+			"%s%s headers are ignored, because blog posts have no parents",
+			positions[HeaderParent].Prefix(),
+			HeaderParent,
 		)
+
+		meta.Parents = nil
 	}
 
 	return meta, data[offset:], nil
 }
+
+// InsertMetaHeader adds header with given value after the last header of the
+// header block. If data has no headers, header is added at the beginning.
+func InsertMetaHeader(data []byte, header string, value string) []byte {
+	var (
+		lines  = bytes.SplitAfter(data, []byte("\n"))
+		offset int
+	)
+
+	for _, line := range lines {
+		if !reHeaderPatternV2.Match(line) && !reHeaderPatternV1.Match(line) {
+			break
+		}
+
+		offset += len(line)
+	}
+
+	result := append([]byte{}, data[:offset]...)
+	if offset > 0 && !bytes.HasSuffix(result, []byte("\n")) {
+		result = append(result, '\n')
+	}
+
+	result = append(
+		result,
+		[]byte(fmt.Sprintf("<!-- %s: %s -->\n", header, value))...,
+	)
+
+	if offset == 0 {
+		result = append(result, '\n')
+	}
+
+	return append(result, data[offset:]...)
+}
@@ -0,0 +1,60 @@
+package mark
+
+import (
+	"sync"
+
+	"github.com/reconquest/karma-go"
+)
+
+// parallelWorkers is a number of tasks which are run at the same time by
+// runParallel, so Confluence is not flooded with requests.
+const parallelWorkers = 4
+
+// runParallel calls task for every index in [0, count) using bounded number
+// of goroutines and returns errors of all failed tasks in order of indexes.
+func runParallel(count int, task func(int) error) []error {
+	var (
+		errs  = make([]error, count)
+		queue = make(chan int)
+		group sync.WaitGroup
+	)
+
+	for worker := 0; worker < parallelWorkers && worker < count; worker++ {
+		group.Add(1)
+
+		go func() {
+			defer group.Done()
+
+			for i := range queue {
+				errs[i] = task(i)
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		queue <- i
+	}
+
+	close(queue)
+
+	group.Wait()
+
+	failed := []error{}
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+
+	return failed
+}
+
+// pushErrors combines multiple errors into one hierarchical error.
+func pushErrors(message string, errs []error) error {
+	reasons := []karma.Reason{}
+	for _, err := range errs {
+		reasons = append(reasons, err)
+	}
+
+	return karma.Push(message, reasons...)
+}
@@ -0,0 +1,178 @@
+package position
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+
+	"github.com/reconquest/karma-go"
+)
+
+// Position is a location of directive in the markdown file.
+type Position struct {
+	File string
+	Line int
+}
+
+func (position Position) String() string {
+	if position.Line == 0 {
+		return position.File
+	}
+
+	return fmt.Sprintf("%s:%d", position.File, position.Line)
+}
+
+// Prefix returns position formatted as prefix of the message, or empty
+// string if position is unknown.
+func (position Position) Prefix() string {
+	if position.File == "" {
+		return ""
+	}
+
+	return position.String() + ": "
+}
+
+// JoinErrors returns errors found at different positions in the file as
+// single error, so all of them are reported at once: the only error is
+// returned as is, and several errors are listed under given message. It
+// returns nil if there are no errors.
+func JoinErrors(message string, errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+
+	case 1:
+		return errs[0]
+	}
+
+	reasons := []karma.Reason{}
+	for _, err := range errs {
+		reasons = append(reasons, err)
+	}
+
+	return karma.Push(message, reasons...)
+}
+
+// Map keeps track of lines of the markdown file while the contents is
+// processed: header block is stripped, includes are expanded and directives
+// are removed. Lines inserted by the replacement point to the line of the
+// replaced directive.
+//
+// Nil map can be used when contents doesn't come from the file, in which case
+// positions are unknown.
+type Map struct {
+	file string
+
+	// lines contains line number in the file for every line of contents.
+	lines []int
+}
+
+// New returns map for contents which starts at the given line of the file.
+func New(file string, line int, contents []byte) *Map {
+	lines := &Map{file: file}
+
+	count := bytes.Count(contents, []byte("\n")) + 1
+	for i := 0; i < count; i++ {
+		lines.lines = append(lines.lines, line+i)
+	}
+
+	return lines
+}
+
+// Position returns position of byte with given index in the contents.
+func (lines *Map) Position(contents []byte, index int) Position {
+	if lines == nil || len(lines.lines) == 0 {
+		return Position{}
+	}
+
+	line := bytes.Count(contents[:index], []byte("\n"))
+	if line >= len(lines.lines) {
+		line = len(lines.lines) - 1
+	}
+
+	return Position{
+		File: lines.file,
+		Line: lines.lines[line],
+	}
+}
+
+// ReplaceAllFunc works like regexp.ReplaceAllFunc, but also passes position
+// of the match to the callback and updates the map to match the result.
+func (lines *Map) ReplaceAllFunc(
+	expression *regexp.Regexp,
+	contents []byte,
+	replace func(match []byte, position Position) []byte,
+) []byte {
+	var (
+		result []byte
+		mapped []int
+		last   int
+
+		// line of contents which is currently being copied
+		line int
+	)
+
+	// push appends data to the result and remembers origin of every line
+	// which starts in the data
+	push := func(data []byte, origin func() int) {
+		for _, symbol := range data {
+			if len(result) == 0 || result[len(result)-1] == '\n' {
+				mapped = append(mapped, origin())
+			}
+
+			result = append(result, symbol)
+		}
+	}
+
+	source := func() int {
+		if lines == nil || len(lines.lines) == 0 {
+			return 0
+		}
+
+		if line >= len(lines.lines) {
+			return lines.lines[len(lines.lines)-1]
+		}
+
+		return lines.lines[line]
+	}
+
+	for _, match := range expression.FindAllIndex(contents, -1) {
+		for _, symbol := range contents[last:match[0]] {
+			push([]byte{symbol}, source)
+
+			if symbol == '\n' {
+				line++
+			}
+		}
+
+		position := lines.Position(contents, match[0])
+
+		push(
+			replace(contents[match[0]:match[1]], position),
+			func() int {
+				return position.Line
+			},
+		)
+
+		line += bytes.Count(contents[match[0]:match[1]], []byte("\n"))
+		last = match[1]
+	}
+
+	for _, symbol := range contents[last:] {
+		push([]byte{symbol}, source)
+
+		if symbol == '\n' {
+			line++
+		}
+	}
+
+	if lines != nil {
+		if len(result) == 0 || result[len(result)-1] == '\n' {
+			mapped = append(mapped, source())
+		}
+
+		lines.lines = mapped
+	}
+
+	return result
+}
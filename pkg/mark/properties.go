@@ -0,0 +1,220 @@
+package mark
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/kovetskiy/mark/pkg/confluence"
+	"github.com/kovetskiy/mark/pkg/log"
+	"github.com/reconquest/karma-go"
+)
+
+const (
+	// AppearanceFullWidth and AppearanceFixed are values of Appearance
+	// header.
+	AppearanceFullWidth = `full-width`
+	AppearanceFixed     = `fixed`
+
+	// PropertyProperties is a content property of page which holds editor
+	// properties set by mark along with their values, so properties are
+	// removed when their headers are removed and are not requested one by
+	// one each time the page is published.
+	PropertyProperties = `mark-properties`
+
+	// default position of cover picture, which is vertically centered
+	coverPosition = 50
+)
+
+// appearances maps values of Appearance header to values of
+// content-appearance properties which Confluence uses.
+var appearances = map[string]string{
+	AppearanceFullWidth: `full-width`,
+	AppearanceFixed:     `fixed-width`,
+}
+
+var reEmojiCode = regexp.MustCompile(`^[0-9a-fA-F]+(-[0-9a-fA-F]+)*$`)
+
+// SetPageProperties applies page appearance, title emoji and cover picture
+// from metadata to the page. Confluence editor v2 reads these settings from
+// content properties, and each of them has separate properties for published
+// version and for draft. Properties which were set by mark before, but have
+// no header anymore, are removed.
+func SetPageProperties(
+	api confluence.Client,
+	page *confluence.PageInfo,
+	meta *Meta,
+) error {
+	properties, err := getPageProperties(meta)
+	if err != nil {
+		return err
+	}
+
+	previous, err := getPublishedProperties(api, page)
+	if err != nil {
+		return err
+	}
+
+	if len(properties) == 0 && len(previous) == 0 {
+		return nil
+	}
+
+	for _, name := range getSortedKeys(properties) {
+		value := properties[name]
+
+		if value == previous[name] {
+			continue
+		}
+
+		for _, key := range getPropertyKeys(name) {
+			err := api.SetContentProperty(page.ID, key, value)
+			if err != nil {
+				return karma.Format(
+					err,
+					"unable to set property %q of page %q",
+					key,
+					page.Title,
+				)
+			}
+		}
+	}
+
+	for _, name := range getSortedKeys(previous) {
+		if _, ok := properties[name]; ok {
+			continue
+		}
+
+		log.Infof(nil, "removing property %q of page %q", name, page.Title)
+
+		for _, key := range getPropertyKeys(name) {
+			err := api.DeleteContentProperty(page.ID, key)
+			if err != nil {
+				return karma.Format(
+					err,
+					"unable to remove property %q of page %q",
+					key,
+					page.Title,
+				)
+			}
+		}
+	}
+
+	if len(properties) == 0 {
+		err = api.DeleteContentProperty(page.ID, PropertyProperties)
+	} else {
+		var value []byte
+
+		value, err = json.Marshal(properties)
+		if err == nil {
+			err = api.SetContentProperty(
+				page.ID,
+				PropertyProperties,
+				string(value),
+			)
+		}
+	}
+	if err != nil {
+		return karma.Format(
+			err,
+			"unable to store properties of page %q",
+			page.Title,
+		)
+	}
+
+	return nil
+}
+
+// getPageProperties returns values of editor properties specified by
+// metadata, keyed by property name without -published and -draft suffix.
+func getPageProperties(meta *Meta) (map[string]string, error) {
+	properties := map[string]string{}
+
+	if meta.Appearance != "" {
+		properties["content-appearance"] = appearances[meta.Appearance]
+	}
+
+	if meta.Emoji != "" {
+		properties["emoji-title"] = getEmojiCode(meta.Emoji)
+	}
+
+	if meta.Cover != "" {
+		cover, err := json.Marshal(map[string]interface{}{
+			"id":       meta.Cover,
+			"position": coverPosition,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		properties["cover-picture-id"] = string(cover)
+	}
+
+	return properties, nil
+}
+
+// getPublishedProperties returns editor properties which were set by mark
+// last time the page was published.
+func getPublishedProperties(
+	api confluence.Client,
+	page *confluence.PageInfo,
+) (map[string]string, error) {
+	property, err := api.GetContentProperty(page.ID, PropertyProperties)
+	if err != nil {
+		return nil, karma.Format(
+			err,
+			"unable to obtain properties of page %q",
+			page.Title,
+		)
+	}
+
+	properties := map[string]string{}
+
+	if property == nil {
+		return properties, nil
+	}
+
+	err = json.Unmarshal([]byte(fmt.Sprint(property.Value)), &properties)
+	if err != nil {
+		return nil, karma.Format(
+			err,
+			"invalid properties of page %q: %q",
+			page.Title,
+			property.Value,
+		)
+	}
+
+	return properties, nil
+}
+
+func getPropertyKeys(name string) []string {
+	return []string{name + "-published", name + "-draft"}
+}
+
+func getSortedKeys(properties map[string]string) []string {
+	keys := []string{}
+	for key := range properties {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// getEmojiCode converts emoji into hex code points separated by dash, which
+// is the format Confluence uses. Values which are already in that format are
+// returned as is.
+func getEmojiCode(emoji string) string {
+	if reEmojiCode.MatchString(emoji) {
+		return strings.ToLower(emoji)
+	}
+
+	codes := []string{}
+	for _, symbol := range emoji {
+		codes = append(codes, fmt.Sprintf("%x", symbol))
+	}
+
+	return strings.Join(codes, "-")
+}
@@ -0,0 +1,225 @@
+package mark
+
+import (
+	"strings"
+
+	"github.com/kovetskiy/mark/pkg/confluence"
+	"github.com/kovetskiy/mark/pkg/log"
+	"github.com/reconquest/karma-go"
+)
+
+const (
+	// LabelManagedRoot marks page which subtree is fully managed by mark,
+	// so pages in it which don't correspond to any source file can be
+	// removed.
+	LabelManagedRoot = `mark-root`
+)
+
+// FindStalePages returns pages under managed roots of published pages which
+// are neither published nor ancestors of published pages. Managed root is any
+// ancestor of published page (or published page itself) which has
+// LabelManagedRoot label.
+//
+// Only descendants of parents of published pages are considered (or of the
+// published page itself if it's a managed root), so publishing a part of the
+// managed tree doesn't mark pages published from other files as stale.
+//
+// Pages are returned in the order they should be removed: children always
+// go before their parents.
+func FindStalePages(
+	api confluence.Client,
+	published []*confluence.PageInfo,
+) ([]confluence.PageInfo, error) {
+	var (
+		keep   = map[string]bool{}
+		labels = map[string]bool{}
+		scopes = []confluence.PageInfo{}
+	)
+
+	for _, page := range published {
+		keep[page.ID] = true
+
+		for _, ancestor := range page.Ancestors {
+			keep[ancestor.Id] = true
+		}
+	}
+
+	for _, page := range published {
+		candidates := append([]confluence.PageAncestor{}, page.Ancestors...)
+		candidates = append(candidates, confluence.PageAncestor{
+			Id:    page.ID,
+			Title: page.Title,
+		})
+
+		managed := false
+
+		for _, candidate := range candidates {
+			root, checked := labels[candidate.Id]
+			if !checked {
+				var err error
+
+				root, err = hasLabel(api, candidate.Id, LabelManagedRoot)
+				if err != nil {
+					return nil, karma.Format(
+						err,
+						"unable to obtain labels of page %q",
+						candidate.Title,
+					)
+				}
+
+				labels[candidate.Id] = root
+			}
+
+			// nested roots are covered by the topmost one
+			if root {
+				managed = true
+
+				break
+			}
+		}
+
+		if !managed {
+			continue
+		}
+
+		// the last candidate is the page itself, so the scope is its parent
+		// unless the page is the managed root
+		scope := len(candidates) - 2
+		if scope < 0 || labels[page.ID] {
+			scope = len(candidates) - 1
+		}
+
+		scopes = append(scopes, confluence.PageInfo{
+			ID:        candidates[scope].Id,
+			Title:     candidates[scope].Title,
+			Ancestors: candidates[:scope],
+		})
+	}
+
+	if len(scopes) == 0 {
+		log.Warningf(
+			nil,
+			"no managed roots found, label root page with %q label "+
+				"to allow pruning pages under it",
+			LabelManagedRoot,
+		)
+
+		return nil, nil
+	}
+
+	stale := []confluence.PageInfo{}
+	visited := map[string]bool{}
+
+	var walk func(page confluence.PageInfo) error
+	walk = func(page confluence.PageInfo) error {
+		if visited[page.ID] {
+			return nil
+		}
+
+		visited[page.ID] = true
+
+		children, err := api.GetChildPages(page.ID)
+		if err != nil {
+			return karma.Format(
+				err,
+				"unable to obtain child pages of page %q",
+				page.Title,
+			)
+		}
+
+		for _, child := range children {
+			child.Ancestors = append(
+				append([]confluence.PageAncestor{}, page.Ancestors...),
+				confluence.PageAncestor{
+					Id:    page.ID,
+					Title: page.Title,
+				},
+			)
+
+			err := walk(child)
+			if err != nil {
+				return err
+			}
+
+			if !keep[child.ID] {
+				stale = append(stale, child)
+			}
+		}
+
+		return nil
+	}
+
+	for _, scope := range scopes {
+		log.Debugf(nil, "looking for stale pages under %q", scope.Title)
+
+		err := walk(scope)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return stale, nil
+}
+
+// PrunePages removes given pages by moving them to trash or archiving them.
+func PrunePages(
+	api confluence.Client,
+	pages []confluence.PageInfo,
+	archive bool,
+) error {
+	for _, page := range pages {
+		var err error
+
+		if archive {
+			log.Infof(nil, "archiving page: %s", GetPagePath(page))
+
+			err = api.ArchivePage(page.ID)
+		} else {
+			log.Infof(nil, "removing page: %s", GetPagePath(page))
+
+			err = api.DeletePage(page.ID)
+		}
+
+		if err != nil {
+			return karma.Format(
+				err,
+				"unable to prune page %q",
+				page.Title,
+			)
+		}
+	}
+
+	return nil
+}
+
+// GetPagePath returns human-readable path of page in the page tree.
+func GetPagePath(page confluence.PageInfo) string {
+	return getAncestryPath(append(
+		append([]confluence.PageAncestor{}, page.Ancestors...),
+		confluence.PageAncestor{Id: page.ID, Title: page.Title},
+	))
+}
+
+func getAncestryPath(ancestors []confluence.PageAncestor) string {
+	titles := []string{}
+	for _, ancestor := range ancestors {
+		titles = append(titles, ancestor.Title)
+	}
+
+	return strings.Join(titles, ` > `)
+}
+
+func hasLabel(api confluence.Client, pageID string, name string) (bool, error) {
+	labels, err := api.GetPageLabels(pageID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, label := range labels {
+		if label.Name == name {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
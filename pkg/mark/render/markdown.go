@@ -0,0 +1,704 @@
+package render
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// prefixes maps namespaces of storage format to prefixes used in documents.
+var prefixes = map[string]string{
+	namespaceAC:                     "ac",
+	namespaceRI:                     "ri",
+	"http://atlassian.com/template": "at",
+}
+
+// inlines are HTML elements which are parts of paragraph.
+var inlines = map[string]bool{
+	"a":      true,
+	"b":      true,
+	"br":     true,
+	"code":   true,
+	"del":    true,
+	"em":     true,
+	"i":      true,
+	"img":    true,
+	"s":      true,
+	"span":   true,
+	"strong": true,
+	"sub":    true,
+	"sup":    true,
+	"u":      true,
+}
+
+// inlineMacros are macros which are parts of paragraph.
+var inlineMacros = map[string]bool{
+	"status": true,
+	"jira":   true,
+}
+
+var (
+	reMarkdownSpecial = regexp.MustCompile("([\\\\`*_\\[\\]])")
+	reSpaces          = regexp.MustCompile(`\s+`)
+
+	// reAttachmentLink matches links to attachments which mark uses for
+	// attachments referenced by plain links and images
+	reAttachmentLink = regexp.MustCompile(
+		`/download/attachments/\d+/([^/?]+)(\?.*)?$`,
+	)
+)
+
+// Document is a page in Confluence storage format converted into markdown.
+type Document struct {
+	Markdown string
+
+	// Layout is a value of Layout header, it's "article" if the page content
+	// is wrapped into the layout which mark adds for article layout.
+	Layout string
+}
+
+type converter struct {
+	// attachments returns local path of attachment with given name.
+	attachments func(name string) string
+
+	layout string
+}
+
+// Markdown converts document in Confluence storage format into markdown.
+// Code blocks, statuses, Jira issues, table of contents, images and user
+// mentions are converted into markdown and mark templates and macros. Other
+// Confluence elements are kept in storage format, which is passed to
+// Confluence by mark as is. Given function returns local paths of
+// attachments, which are used in links and images instead of attachment
+// names.
+func Markdown(
+	storage string,
+	attachments func(name string) string,
+) (*Document, error) {
+	root, err := parse(storage)
+	if err != nil {
+		return nil, err
+	}
+
+	converter := &converter{attachments: attachments}
+
+	markdown := strings.Join(converter.blocks(root), "\n\n")
+	if markdown != "" {
+		markdown += "\n"
+	}
+
+	return &Document{
+		Markdown: markdown,
+		Layout:   converter.layout,
+	}, nil
+}
+
+func (converter *converter) isInline(node *element) bool {
+	switch node.name.Space {
+	case "":
+		return node.name.Local == "" || inlines[node.name.Local]
+
+	case namespaceAC:
+		switch node.name.Local {
+		case "link", "image", "emoticon", "placeholder":
+			return true
+
+		case "structured-macro":
+			return inlineMacros[node.attr(namespaceAC, "name")]
+		}
+	}
+
+	return false
+}
+
+// blocks converts children of the node into markdown blocks, which should
+// be separated by empty lines.
+func (converter *converter) blocks(node *element) []string {
+	var (
+		blocks    = []string{}
+		paragraph = []*element{}
+	)
+
+	flush := func() {
+		text := strings.TrimSpace(converter.inlines(paragraph))
+		if text != "" {
+			blocks = append(blocks, text)
+		}
+
+		paragraph = []*element{}
+	}
+
+	for _, child := range node.children {
+		if converter.isInline(child) {
+			paragraph = append(paragraph, child)
+
+			continue
+		}
+
+		flush()
+
+		blocks = append(blocks, converter.block(child)...)
+	}
+
+	flush()
+
+	return blocks
+}
+
+func (converter *converter) block(node *element) []string {
+	if node.name.Space == namespaceAC {
+		return converter.content(node)
+	}
+
+	if node.name.Space != "" {
+		return []string{raw(node)}
+	}
+
+	switch name := node.name.Local; name {
+	case "p":
+		text := strings.TrimSpace(converter.inlines(node.children))
+		if text == "" {
+			return nil
+		}
+
+		return []string{text}
+
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		return []string{
+			strings.Repeat("#", int(name[1]-'0')) + " " +
+				strings.TrimSpace(converter.inlines(node.children)),
+		}
+
+	case "ul", "ol":
+		return []string{converter.list(node, name == "ol")}
+
+	case "pre":
+		return []string{fence("", node.content())}
+
+	case "blockquote":
+		lines := strings.Split(
+			strings.Join(converter.blocks(node), "\n\n"),
+			"\n",
+		)
+
+		for i, line := range lines {
+			lines[i] = strings.TrimRight("> "+line, " ")
+		}
+
+		return []string{strings.Join(lines, "\n")}
+
+	case "hr":
+		return []string{"---"}
+
+	case "table":
+		return []string{converter.table(node)}
+
+	case "div", "section", "tbody", "thead":
+		return converter.blocks(node)
+
+	default:
+		return []string{raw(node)}
+	}
+}
+
+// content converts block ac: elements.
+func (converter *converter) content(node *element) []string {
+	switch node.name.Local {
+	case "structured-macro":
+		return []string{converter.macro(node)}
+
+	case "layout":
+		// mark wraps page into such layout when Layout header is "article"
+		sections := []*element{}
+		for _, child := range node.children {
+			if child.name.Local == "layout-section" {
+				sections = append(sections, child)
+			}
+		}
+
+		if len(sections) != 1 ||
+			sections[0].attr(namespaceAC, "type") != "two_right_sidebar" {
+			return []string{raw(node)}
+		}
+
+		cells := []*element{}
+		for _, child := range sections[0].children {
+			if child.name.Local == "layout-cell" {
+				cells = append(cells, child)
+			}
+		}
+
+		if len(cells) != 2 || len(cells[1].children) != 0 {
+			return []string{raw(node)}
+		}
+
+		converter.layout = "article"
+
+		return converter.blocks(cells[0])
+
+	default:
+		return []string{raw(node)}
+	}
+}
+
+func (converter *converter) macro(node *element) string {
+	var (
+		name   = node.attr(namespaceAC, "name")
+		params = map[string]*element{}
+	)
+
+	for _, child := range node.children {
+		if child.name.Space == namespaceAC && child.name.Local == "parameter" {
+			params[child.attr(namespaceAC, "name")] = child
+		}
+	}
+
+	param := func(name string) string {
+		return strings.TrimSpace(params[name].content())
+	}
+
+	switch name {
+	case "code", "noformat":
+		return fence(
+			param("language"),
+			node.child(namespaceAC, "plain-text-body").content(),
+		)
+
+	case "status":
+		return include(
+			"ac:status",
+			"Title", param("title"),
+			"Color", param("colour"),
+		)
+
+	case "jira":
+		if param("key") == "" {
+			break
+		}
+
+		return include("ac:jira:ticket", "Ticket", param("key"))
+
+	case "toc":
+		return include("ac:toc", "Exclude", param("exclude"))
+
+	case "view-file", "multimedia":
+		file := params["name"].child(namespaceRI, "attachment")
+		if file == nil {
+			break
+		}
+
+		return include(
+			"ac:"+name,
+			"Name", converter.attachments(file.attr(namespaceRI, "filename")),
+		)
+	}
+
+	return raw(node)
+}
+
+func (converter *converter) list(node *element, ordered bool) string {
+	items := []string{}
+
+	number := 0
+	for _, child := range node.children {
+		if child.name.Local != "li" {
+			continue
+		}
+
+		number++
+
+		marker := "- "
+		if ordered {
+			marker = fmt.Sprintf("%d. ", number)
+		}
+
+		indent := strings.Repeat(" ", len(marker))
+
+		lines := strings.Split(
+			strings.Join(converter.blocks(child), "\n\n"),
+			"\n",
+		)
+
+		for i := range lines {
+			if i == 0 {
+				lines[i] = marker + lines[i]
+			} else if lines[i] != "" {
+				lines[i] = indent + lines[i]
+			}
+		}
+
+		items = append(items, strings.Join(lines, "\n"))
+	}
+
+	return strings.Join(items, "\n")
+}
+
+// table converts table into markdown table, first row is used as header.
+// Tables with cells which contain blocks, like lists or code, can't be
+// represented in markdown and are kept as is.
+func (converter *converter) table(node *element) string {
+	rows := [][]string{}
+
+	var walk func(node *element) bool
+	walk = func(node *element) bool {
+		for _, child := range node.children {
+			switch child.name.Local {
+			case "tbody", "thead", "tfoot":
+				if !walk(child) {
+					return false
+				}
+
+			case "tr":
+				row := []string{}
+
+				for _, cell := range child.children {
+					if cell.name.Local != "td" && cell.name.Local != "th" {
+						continue
+					}
+
+					if cell.attr("", "colspan") != "" ||
+						cell.attr("", "rowspan") != "" {
+						return false
+					}
+
+					blocks := converter.blocks(cell)
+					for _, block := range blocks {
+						if strings.Contains(block, "\n") {
+							return false
+						}
+					}
+
+					row = append(
+						row,
+						strings.ReplaceAll(
+							strings.Join(blocks, "<br/>"),
+							"|",
+							"\\|",
+						),
+					)
+				}
+
+				rows = append(rows, row)
+			}
+		}
+
+		return true
+	}
+
+	if !walk(node) || len(rows) == 0 {
+		return raw(node)
+	}
+
+	columns := 0
+	for _, row := range rows {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+
+	lines := []string{}
+	for i, row := range rows {
+		for len(row) < columns {
+			row = append(row, "")
+		}
+
+		lines = append(lines, "| "+strings.Join(row, " | ")+" |")
+
+		if i == 0 {
+			lines = append(
+				lines,
+				"|"+strings.Repeat(" --- |", columns),
+			)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (converter *converter) inlines(nodes []*element) string {
+	var buffer bytes.Buffer
+
+	for _, node := range nodes {
+		buffer.WriteString(converter.inline(node))
+	}
+
+	return buffer.String()
+}
+
+func (converter *converter) inline(node *element) string {
+	switch node.name.Space {
+	case "":
+
+	case namespaceAC:
+		return converter.inlineContent(node)
+
+	default:
+		return raw(node)
+	}
+
+	children := func() string {
+		return converter.inlines(node.children)
+	}
+
+	// emphasis markers can't be separated from text by spaces
+	wrap := func(marker string) string {
+		text := children()
+		if strings.TrimSpace(text) == "" {
+			return text
+		}
+
+		trimmed := strings.TrimSpace(text)
+
+		return text[:strings.Index(text, trimmed)] +
+			marker + trimmed + marker +
+			text[strings.Index(text, trimmed)+len(trimmed):]
+	}
+
+	switch node.name.Local {
+	case "":
+		return escape(reSpaces.ReplaceAllString(node.text, " "))
+
+	case "strong", "b":
+		return wrap("**")
+
+	case "em", "i":
+		return wrap("*")
+
+	case "del", "s":
+		return wrap("~~")
+
+	case "code":
+		text := node.content()
+		if strings.Contains(text, "`") {
+			return "`` " + text + " ``"
+		}
+
+		return "`" + text + "`"
+
+	case "a":
+		href := node.attr("", "href")
+		if href == "" {
+			return children()
+		}
+
+		return "[" + children() + "](" + destination(converter.href(href)) + ")"
+
+	case "br":
+		return "<br/>"
+
+	case "img":
+		return "![" + escape(node.attr("", "alt")) + "](" +
+			destination(converter.href(node.attr("", "src"))) + ")"
+
+	default:
+		return raw(node)
+	}
+}
+
+// href replaces links to attachments with their local paths.
+func (converter *converter) href(link string) string {
+	matches := reAttachmentLink.FindStringSubmatch(link)
+	if matches == nil {
+		return link
+	}
+
+	name, err := url.PathUnescape(matches[1])
+	if err != nil {
+		return link
+	}
+
+	return converter.attachments(name)
+}
+
+// inlineContent converts inline ac: elements.
+func (converter *converter) inlineContent(node *element) string {
+	switch node.name.Local {
+	case "structured-macro":
+		return converter.macro(node)
+
+	case "link":
+		return converter.link(node)
+
+	case "image":
+		return converter.image(node)
+
+	default:
+		return raw(node)
+	}
+}
+
+func (converter *converter) link(node *element) string {
+	text := node.child(namespaceAC, "plain-text-link-body").content()
+	if body := node.child(namespaceAC, "link-body"); body != nil {
+		text = converter.inlines(body.children)
+	} else {
+		text = escape(text)
+	}
+
+	if user := node.child(namespaceRI, "user"); user != nil {
+		// users are looked up by name, which is not known for Confluence
+		// Cloud users identified by account ID only
+		if name := user.attr(namespaceRI, "username"); name != "" {
+			return "@{" + name + "}"
+		}
+
+		return raw(node)
+	}
+
+	if attachment := node.child(namespaceRI, "attachment"); attachment != nil &&
+		node.attr(namespaceAC, "anchor") == "" {
+		name := attachment.attr(namespaceRI, "filename")
+		if text == "" {
+			text = escape(name)
+		}
+
+		return "[" + text + "](" + destination(converter.attachments(name)) + ")"
+	}
+
+	return raw(node)
+}
+
+func (converter *converter) image(node *element) string {
+	alt := node.attr(namespaceAC, "alt")
+
+	if remote := node.child(namespaceRI, "url"); remote != nil {
+		return "![" + escape(alt) + "](" +
+			destination(remote.attr(namespaceRI, "value")) + ")"
+	}
+
+	attachment := node.child(namespaceRI, "attachment")
+	if attachment == nil || attachment.child(namespaceRI, "page") != nil {
+		return raw(node)
+	}
+
+	path := converter.attachments(attachment.attr(namespaceRI, "filename"))
+
+	var (
+		width  = node.attr(namespaceAC, "width")
+		height = node.attr(namespaceAC, "height")
+		title  = node.attr(namespaceAC, "title")
+	)
+
+	if width == "" && height == "" && title == "" {
+		return "![" + escape(alt) + "](" + destination(path) + ")"
+	}
+
+	return include(
+		"ac:image",
+		"Name", path,
+		"Width", width,
+		"Height", height,
+		"Title", title,
+		"Alt", alt,
+	)
+}
+
+// include returns Include directive of mark with given template and data,
+// pairs with empty values are omitted.
+func include(template string, pairs ...string) string {
+	lines := []string{"<!-- Include: " + template}
+
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] == "" && pairs[i] != "Exclude" {
+			continue
+		}
+
+		lines = append(
+			lines,
+			fmt.Sprintf("     %s: %q", pairs[i], pairs[i+1]),
+		)
+	}
+
+	return strings.Join(lines, "\n") + " -->"
+}
+
+func fence(language string, code string) string {
+	marker := "```"
+	for strings.Contains(code, marker) {
+		marker += "`"
+	}
+
+	return marker + language + "\n" + strings.TrimSuffix(code, "\n") + "\n" +
+		marker
+}
+
+// destination returns link destination in markdown form, destinations with
+// spaces (like names of attachments) are enclosed in angle brackets.
+func destination(link string) string {
+	if strings.ContainsAny(link, " \t") {
+		return "<" + link + ">"
+	}
+
+	return link
+}
+
+func escape(text string) string {
+	text = reMarkdownSpecial.ReplaceAllString(text, `\$1`)
+	text = strings.ReplaceAll(text, "<", "&lt;")
+
+	return text
+}
+
+// raw returns node in storage format.
+func raw(node *element) string {
+	var buffer bytes.Buffer
+
+	writeRaw(&buffer, node)
+
+	return buffer.String()
+}
+
+func writeRaw(buffer *bytes.Buffer, node *element) {
+	if node.name.Local == "" {
+		xml.EscapeText(buffer, []byte(node.text))
+
+		return
+	}
+
+	name := getRawName(node.name)
+
+	buffer.WriteString("<" + name)
+
+	for _, attr := range node.attrs {
+		buffer.WriteString(" " + getRawName(attr.Name) + `="`)
+		xml.EscapeText(buffer, []byte(attr.Value))
+		buffer.WriteString(`"`)
+	}
+
+	if len(node.children) == 0 {
+		buffer.WriteString("/>")
+
+		return
+	}
+
+	buffer.WriteString(">")
+
+	if node.name.Space == namespaceAC && node.name.Local == "plain-text-body" {
+		buffer.WriteString("<![CDATA[")
+		buffer.WriteString(
+			strings.ReplaceAll(
+				node.content(),
+				"]]>",
+				"]]]]><![CDATA[>",
+			),
+		)
+		buffer.WriteString("]]>")
+	} else {
+		for _, child := range node.children {
+			writeRaw(buffer, child)
+		}
+	}
+
+	buffer.WriteString("</" + name + ">")
+}
+
+func getRawName(name xml.Name) string {
+	if prefix, ok := prefixes[name.Space]; ok {
+		return prefix + ":" + name.Local
+	}
+
+	return name.Local
+}
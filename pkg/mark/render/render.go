@@ -0,0 +1,583 @@
+package render
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/reconquest/karma-go"
+)
+
+const (
+	namespaceAC = `http://atlassian.com/content`
+	namespaceRI = `http://atlassian.com/resource/identifier`
+)
+
+// StorageHeader declares namespaces which are used in Confluence storage
+// format, but never declared in the page itself. It has no newlines, so line
+// numbers of the document are kept.
+const StorageHeader = `<ac:confluence` +
+	` xmlns:ac="` + namespaceAC + `"` +
+	` xmlns:ri="` + namespaceRI + `"` +
+	` xmlns:at="http://atlassian.com/template">`
+
+const storageFooter = `</ac:confluence>`
+
+// voids are HTML elements which have no closing tag.
+var voids = map[string]bool{
+	"br":    true,
+	"hr":    true,
+	"img":   true,
+	"col":   true,
+	"input": true,
+}
+
+// panels are macros which are rendered as colored boxes around their body.
+var panels = map[string]bool{
+	"info":    true,
+	"note":    true,
+	"tip":     true,
+	"warning": true,
+	"panel":   true,
+}
+
+// Stylesheet is CSS for the HTML returned by HTML, which makes it look
+// roughly like a page in Confluence.
+const Stylesheet = `
+body {
+	font-family: -apple-system, "Segoe UI", Roboto, Helvetica, Arial, sans-serif;
+	font-size: 14px;
+	line-height: 1.6;
+	color: #172b4d;
+	max-width: 960px;
+	margin: 0 auto;
+	padding: 24px;
+}
+table { border-collapse: collapse; }
+th, td { border: 1px solid #c1c7d0; padding: 7px 10px; }
+th { background: #f4f5f7; }
+pre.code { background: #f4f5f7; border: 1px solid #dfe1e6; padding: 8px; overflow: auto; }
+.code-title { background: #ebecf0; border: 1px solid #dfe1e6; border-bottom: 0; padding: 4px 8px; font-weight: bold; }
+.status { display: inline-block; padding: 0 4px; border-radius: 3px; font-size: 11px; font-weight: bold; text-transform: uppercase; border: 1px solid; }
+.status-grey { background: #dfe1e6; border-color: #dfe1e6; }
+.status-red { background: #ffebe6; border-color: #ffebe6; color: #bf2600; }
+.status-yellow { background: #fffae6; border-color: #fffae6; color: #ff8b00; }
+.status-green { background: #e3fcef; border-color: #e3fcef; color: #006644; }
+.status-blue { background: #deebff; border-color: #deebff; color: #0747a6; }
+.status-purple { background: #eae6ff; border-color: #eae6ff; color: #403294; }
+.panel { border-radius: 3px; padding: 8px 12px; margin: 8px 0; background: #f4f5f7; }
+.panel-info { background: #deebff; }
+.panel-note { background: #eae6ff; }
+.panel-tip { background: #e3fcef; }
+.panel-warning { background: #ffebe6; }
+.panel-title { font-weight: bold; }
+.placeholder { display: inline-block; border: 1px dashed #97a0af; border-radius: 3px; padding: 2px 6px; color: #6b778c; background: #fafbfc; }
+div.placeholder { display: block; margin: 8px 0; }
+.mention { background: #ebecf0; border-radius: 20px; padding: 0 4px; }
+.layout-section { display: flex; }
+.layout-cell { flex: 1; }
+.layout-cell:empty { flex: 0; }
+`
+
+// element is an element or a text of the document.
+type element struct {
+	name     xml.Name
+	attrs    []xml.Attr
+	children []*element
+
+	// text is set for text nodes, which have no name
+	text string
+}
+
+func (node *element) attr(space string, name string) string {
+	if node == nil {
+		return ""
+	}
+
+	for _, attr := range node.attrs {
+		if attr.Name.Space == space && attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+
+	return ""
+}
+
+// child returns first child element with given name, it can be called on
+// nil element.
+func (node *element) child(space string, name string) *element {
+	if node == nil {
+		return nil
+	}
+
+	for _, child := range node.children {
+		if child.name.Space == space && child.name.Local == name {
+			return child
+		}
+	}
+
+	return nil
+}
+
+// content returns concatenated text of the node and its children.
+func (node *element) content() string {
+	if node == nil {
+		return ""
+	}
+
+	text := node.text
+	for _, child := range node.children {
+		text += child.content()
+	}
+
+	return text
+}
+
+type renderer struct {
+	buffer bytes.Buffer
+
+	// attachments is a prefix of URLs of attachments
+	attachments string
+}
+
+// HTML converts document in Confluence storage format into HTML, which is an
+// approximation of the page as Confluence shows it. Macros which can't be
+// rendered without Confluence, like table of contents or Jira issues, are
+// shown as placeholders. Links to attachments are prefixed with given
+// attachments URL.
+func HTML(storage string, attachments string) (string, error) {
+	root, err := parse(storage)
+	if err != nil {
+		return "", err
+	}
+
+	renderer := newRenderer(attachments)
+	renderer.children(root)
+
+	return renderer.buffer.String(), nil
+}
+
+func newRenderer(attachments string) *renderer {
+	return &renderer{attachments: attachments}
+}
+
+// NewStorageDecoder returns XML decoder of given document in Confluence
+// storage format, which is wrapped into StorageHeader, so offsets of the
+// decoder are shifted by its length. HTML entities are accepted, as Confluence
+// does.
+func NewStorageDecoder(storage string) *xml.Decoder {
+	decoder := xml.NewDecoder(
+		strings.NewReader(StorageHeader + storage + storageFooter),
+	)
+
+	decoder.Strict = true
+	decoder.Entity = xml.HTMLEntity
+
+	return decoder
+}
+
+func parse(storage string) (*element, error) {
+	decoder := NewStorageDecoder(storage)
+
+	var (
+		root  = &element{}
+		stack = []*element{root}
+	)
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, karma.Format(
+				err,
+				"unable to parse storage format",
+			)
+		}
+
+		top := stack[len(stack)-1]
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			child := &element{
+				name:  token.Name,
+				attrs: token.Attr,
+			}
+
+			top.children = append(top.children, child)
+			stack = append(stack, child)
+
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+
+		case xml.CharData:
+			top.children = append(top.children, &element{text: string(token)})
+		}
+	}
+
+	// skip wrapping element
+	if len(root.children) == 1 {
+		return root.children[0], nil
+	}
+
+	return root, nil
+}
+
+func (renderer *renderer) write(format string, args ...interface{}) {
+	fmt.Fprintf(&renderer.buffer, format, args...)
+}
+
+func (renderer *renderer) children(node *element) {
+	if node == nil {
+		return
+	}
+
+	for _, child := range node.children {
+		renderer.node(child)
+	}
+}
+
+func (renderer *renderer) node(node *element) {
+	switch node.name.Space {
+	case "":
+		if node.name.Local == "" {
+			renderer.write("%s", html.EscapeString(node.text))
+		} else {
+			renderer.element(node)
+		}
+
+	case namespaceAC:
+		renderer.content(node)
+
+	case namespaceRI:
+		renderer.resource(node)
+
+	default:
+		renderer.children(node)
+	}
+}
+
+// element renders regular HTML element.
+func (renderer *renderer) element(node *element) {
+	renderer.write("<%s", node.name.Local)
+
+	for _, attr := range node.attrs {
+		if attr.Name.Space != "" {
+			continue
+		}
+
+		renderer.write(
+			` %s="%s"`,
+			attr.Name.Local,
+			html.EscapeString(attr.Value),
+		)
+	}
+
+	renderer.write(">")
+
+	if voids[node.name.Local] {
+		return
+	}
+
+	renderer.children(node)
+
+	renderer.write("</%s>", node.name.Local)
+}
+
+// content renders ac: elements.
+func (renderer *renderer) content(node *element) {
+	switch node.name.Local {
+	case "structured-macro", "macro":
+		renderer.macro(node)
+
+	case "link":
+		renderer.link(node)
+
+	case "image":
+		renderer.image(node)
+
+	case "layout", "layout-section", "layout-cell":
+		renderer.write(`<div class="%s">`, node.name.Local)
+		renderer.children(node)
+		renderer.write(`</div>`)
+
+	case "emoticon":
+		renderer.write(
+			`<span class="placeholder">:%s:</span>`,
+			html.EscapeString(node.attr(namespaceAC, "name")),
+		)
+
+	case "task-list":
+		renderer.write(`<ul>`)
+		renderer.children(node)
+		renderer.write(`</ul>`)
+
+	case "task":
+		checked := ""
+		if node.child(namespaceAC, "task-status").content() == "complete" {
+			checked = " checked"
+		}
+
+		renderer.write(`<li><input type="checkbox" disabled%s> `, checked)
+		renderer.children(node.child(namespaceAC, "task-body"))
+		renderer.write(`</li>`)
+
+	case "parameter", "task-id", "task-status":
+
+	default:
+		renderer.children(node)
+	}
+}
+
+// resource renders ri: elements, which are not part of the link or image.
+func (renderer *renderer) resource(node *element) {
+	switch node.name.Local {
+	case "attachment":
+		renderer.write(
+			`<a href="%s">%s</a>`,
+			html.EscapeString(renderer.attachment(node)),
+			html.EscapeString(node.attr(namespaceRI, "filename")),
+		)
+
+	case "user":
+		renderer.write(
+			`<span class="mention">@%s</span>`,
+			html.EscapeString(getUserName(node)),
+		)
+
+	case "page", "blog-post":
+		renderer.write(
+			"%s",
+			html.EscapeString(node.attr(namespaceRI, "content-title")),
+		)
+
+	case "url":
+		renderer.write(
+			"%s",
+			html.EscapeString(node.attr(namespaceRI, "value")),
+		)
+	}
+}
+
+func (renderer *renderer) attachment(node *element) string {
+	return renderer.attachments +
+		url.PathEscape(node.attr(namespaceRI, "filename"))
+}
+
+func (renderer *renderer) macro(macro *element) {
+	var (
+		name   = macro.attr(namespaceAC, "name")
+		params = map[string]*element{}
+		body   = macro.child(namespaceAC, "rich-text-body")
+	)
+
+	for _, child := range macro.children {
+		if child.name.Space == namespaceAC && child.name.Local == "parameter" {
+			params[child.attr(namespaceAC, "name")] = child
+		}
+	}
+
+	param := func(name string) string {
+		return strings.TrimSpace(params[name].content())
+	}
+
+	switch {
+	case name == "code" || name == "noformat":
+		if title := param("title"); title != "" {
+			renderer.write(
+				`<div class="code-title">%s</div>`,
+				html.EscapeString(title),
+			)
+		}
+
+		renderer.write(
+			`<pre class="code"><code class="language-%s">%s</code></pre>`,
+			html.EscapeString(param("language")),
+			html.EscapeString(
+				macro.child(namespaceAC, "plain-text-body").content(),
+			),
+		)
+
+	case name == "status":
+		renderer.write(
+			`<span class="status status-%s">%s</span>`,
+			html.EscapeString(strings.ToLower(param("colour"))),
+			html.EscapeString(param("title")),
+		)
+
+	case name == "jira":
+		renderer.write(
+			`<span class="placeholder">Jira: %s</span>`,
+			html.EscapeString(param("key")),
+		)
+
+	case name == "toc":
+		renderer.write(`<div class="placeholder">Table of contents</div>`)
+
+	case panels[name]:
+		renderer.write(`<div class="panel panel-%s">`, name)
+
+		if title := param("title"); title != "" {
+			renderer.write(
+				`<div class="panel-title">%s</div>`,
+				html.EscapeString(title),
+			)
+		}
+
+		renderer.children(body)
+		renderer.write(`</div>`)
+
+	case name == "expand":
+		title := param("title")
+		if title == "" {
+			title = "Click here to expand..."
+		}
+
+		renderer.write(
+			`<details><summary>%s</summary>`,
+			html.EscapeString(title),
+		)
+		renderer.children(body)
+		renderer.write(`</details>`)
+
+	case name == "view-file" || name == "multimedia":
+		file := params["name"].child(namespaceRI, "attachment")
+		if file == nil {
+			break
+		}
+
+		if name == "multimedia" {
+			renderer.write(
+				`<video controls src="%s"></video>`,
+				html.EscapeString(renderer.attachment(file)),
+			)
+		} else {
+			renderer.write(
+				`<a class="placeholder" href="%s">%s</a>`,
+				html.EscapeString(renderer.attachment(file)),
+				html.EscapeString(file.attr(namespaceRI, "filename")),
+			)
+		}
+
+	default:
+		renderer.write(
+			`<div class="placeholder">Macro: %s`,
+			html.EscapeString(name),
+		)
+		renderer.children(body)
+		renderer.write(`</div>`)
+	}
+}
+
+func (renderer *renderer) link(node *element) {
+	var (
+		text   string
+		target string
+		class  string
+	)
+
+	if body := node.child(namespaceAC, "plain-text-link-body"); body != nil {
+		text = html.EscapeString(body.content())
+	}
+
+	if body := node.child(namespaceAC, "link-body"); body != nil {
+		nested := newRenderer(renderer.attachments)
+		nested.children(body)
+
+		text = nested.buffer.String()
+	}
+
+	anchor := node.attr(namespaceAC, "anchor")
+
+	for _, child := range node.children {
+		if child.name.Space != namespaceRI {
+			continue
+		}
+
+		switch child.name.Local {
+		case "user":
+			if text == "" {
+				text = html.EscapeString(getUserName(child))
+			}
+
+			renderer.write(`<span class="mention">@%s</span>`, text)
+
+			return
+
+		case "attachment":
+			target = renderer.attachment(child)
+
+			if text == "" {
+				text = html.EscapeString(child.attr(namespaceRI, "filename"))
+			}
+
+		case "page", "blog-post":
+			class = "page"
+
+			if text == "" {
+				text = html.EscapeString(
+					child.attr(namespaceRI, "content-title"),
+				)
+			}
+		}
+	}
+
+	if anchor != "" {
+		target += "#" + anchor
+
+		if text == "" {
+			text = html.EscapeString(anchor)
+		}
+	}
+
+	if target == "" {
+		renderer.write(`<a class="%s">%s</a>`, class, text)
+
+		return
+	}
+
+	renderer.write(
+		`<a class="%s" href="%s">%s</a>`,
+		class,
+		html.EscapeString(target),
+		text,
+	)
+}
+
+func (renderer *renderer) image(node *element) {
+	var source string
+
+	if attachment := node.child(namespaceRI, "attachment"); attachment != nil {
+		source = renderer.attachment(attachment)
+	}
+
+	if remote := node.child(namespaceRI, "url"); remote != nil {
+		source = remote.attr(namespaceRI, "value")
+	}
+
+	renderer.write(`<img src="%s"`, html.EscapeString(source))
+
+	for _, name := range []string{"width", "height", "alt", "title"} {
+		if value := node.attr(namespaceAC, name); value != "" {
+			renderer.write(` %s="%s"`, name, html.EscapeString(value))
+		}
+	}
+
+	renderer.write(`>`)
+}
+
+func getUserName(node *element) string {
+	for _, name := range []string{"username", "userkey", "account-id"} {
+		if value := node.attr(namespaceRI, name); value != "" {
+			return value
+		}
+	}
+
+	return "user"
+}
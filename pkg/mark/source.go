@@ -0,0 +1,279 @@
+package mark
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/kovetskiy/mark/pkg/confluence"
+	"github.com/kovetskiy/mark/pkg/log"
+	"github.com/reconquest/karma-go"
+)
+
+const (
+	// PropertySourcePath is a content property which holds path of markdown
+	// file the page is published from.
+	PropertySourcePath = `mark-source-path`
+
+	// LabelSourcePrefix is a prefix of label which is used to find page by
+	// its source path, because content properties are not searchable.
+	LabelSourcePrefix = `mark-source-`
+)
+
+// GetSourcePath returns path of given markdown file relative to the root of
+// git repository it belongs to, so the path doesn't depend on the directory
+// mark is run from. Files outside of git repositories are relative to the
+// current directory.
+func GetSourcePath(file string) string {
+	path, err := filepath.Abs(file)
+	if err != nil {
+		return filepath.ToSlash(file)
+	}
+
+	root := getRepositoryRoot(filepath.Dir(path))
+	if root == "" {
+		root, err = os.Getwd()
+		if err != nil {
+			return filepath.ToSlash(file)
+		}
+	}
+
+	relative, err := filepath.Rel(root, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+
+	return filepath.ToSlash(relative)
+}
+
+// getRepositoryRoot returns the closest directory with .git entry which
+// contains given directory or empty string if there is no such directory.
+func getRepositoryRoot(dir string) string {
+	for {
+		_, err := os.Stat(filepath.Join(dir, ".git"))
+		if err == nil {
+			return dir
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+
+		dir = parent
+	}
+}
+
+// GetPreviousSourcePaths returns paths which given markdown file had before
+// it was renamed or moved according to git history, the most recent first.
+// Paths are relative to the root of git repository like GetSourcePath
+// returns.
+func GetPreviousSourcePaths(file string) []string {
+	cmd := exec.Command(
+		"git", "log", "--follow", "--name-only", "--format=", "--",
+		filepath.Base(file),
+	)
+	cmd.Dir = filepath.Dir(file)
+
+	output, err := cmd.Output()
+	if err != nil {
+		log.Debugf(
+			karma.Describe("file", file).Describe("error", err),
+			"unable to obtain previous paths of file from git history",
+		)
+
+		return nil
+	}
+
+	var (
+		current = GetSourcePath(file)
+		seen    = map[string]bool{current: true}
+		paths   = []string{}
+	)
+
+	for _, path := range strings.Split(string(output), "\n") {
+		path = strings.TrimSpace(path)
+		if path == "" || seen[path] {
+			continue
+		}
+
+		seen[path] = true
+
+		paths = append(paths, path)
+	}
+
+	return paths
+}
+
+// FindPageBySource returns page which was published from given source
+// markdown file or nil if there is no such page.
+func FindPageBySource(
+	api confluence.Client,
+	space string,
+	source string,
+) (*confluence.PageInfo, error) {
+	pages, err := api.FindPagesByLabel(space, getSourceLabel(source))
+	if err != nil {
+		return nil, karma.Format(
+			err,
+			"unable to find pages published from %q",
+			source,
+		)
+	}
+
+	for _, page := range pages {
+		ok, err := IsPageSource(api, &page, source)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			return &page, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// IsPageSource checks that given page was published from given source
+// markdown file.
+func IsPageSource(
+	api confluence.Client,
+	page *confluence.PageInfo,
+	source string,
+) (bool, error) {
+	previous, err := getPageSource(api, page)
+	if err != nil {
+		return false, err
+	}
+
+	return previous == source, nil
+}
+
+// resolveSourcePage checks whether given page was published from the source
+// file of the page described by meta, or finds such page if given page is
+// nil. Previous paths of the file from git history are checked as well, so
+// pages of moved files are found.
+func resolveSourcePage(
+	api confluence.Client,
+	meta *Meta,
+	page *confluence.PageInfo,
+) (*confluence.PageInfo, bool, error) {
+	lookup := func(source string) (*confluence.PageInfo, bool, error) {
+		if page != nil {
+			owned, err := IsPageSource(api, page, source)
+
+			return page, owned, err
+		}
+
+		found, err := FindPageBySource(api, meta.Space, source)
+
+		return found, found != nil, err
+	}
+
+	found, owned, err := lookup(meta.Source)
+	if err != nil || owned || meta.File == "" {
+		return found, owned, err
+	}
+
+	for _, source := range GetPreviousSourcePaths(meta.File) {
+		found, owned, err = lookup(source)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if owned {
+			log.Infof(
+				nil,
+				"page %q was published from %q before the file was moved",
+				found.Title,
+				source,
+			)
+
+			return found, true, nil
+		}
+	}
+
+	return page, false, nil
+}
+
+// SetPageSource stores path of source markdown file in the page, so page can
+// be found by FindPageBySource after it was renamed or moved. Label of the
+// previous source path is removed.
+func SetPageSource(
+	api confluence.Client,
+	page *confluence.PageInfo,
+	source string,
+) error {
+	previous, err := getPageSource(api, page)
+	if err != nil {
+		return err
+	}
+
+	if previous == source {
+		return nil
+	}
+
+	err = api.SetContentProperty(page.ID, PropertySourcePath, source)
+	if err != nil {
+		return karma.Format(
+			err,
+			"unable to set source path of page %q",
+			page.Title,
+		)
+	}
+
+	err = api.AddPageLabels(page.ID, []string{getSourceLabel(source)})
+	if err != nil {
+		return karma.Format(
+			err,
+			"unable to set source label of page %q",
+			page.Title,
+		)
+	}
+
+	if previous != "" {
+		err = api.RemovePageLabel(page.ID, getSourceLabel(previous))
+		if err != nil {
+			return karma.Format(
+				err,
+				"unable to remove previous source label of page %q",
+				page.Title,
+			)
+		}
+	}
+
+	return nil
+}
+
+// getPageSource returns source path stored in the page or empty string if
+// page was not published by mark.
+func getPageSource(
+	api confluence.Client,
+	page *confluence.PageInfo,
+) (string, error) {
+	property, err := api.GetContentProperty(page.ID, PropertySourcePath)
+	if err != nil {
+		return "", karma.Format(
+			err,
+			"unable to obtain source path of page %q",
+			page.Title,
+		)
+	}
+
+	if property == nil {
+		return "", nil
+	}
+
+	return fmt.Sprint(property.Value), nil
+}
+
+func getSourceLabel(source string) string {
+	hash := sha256.Sum256([]byte(source))
+
+	return LabelSourcePrefix + hex.EncodeToString(hash[:])[:16]
+}
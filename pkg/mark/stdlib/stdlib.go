@@ -16,7 +16,7 @@ type Lib struct {
 	Templates *template.Template
 }
 
-func New(api *confluence.API) (*Lib, error) {
+func New(api confluence.Client) (*Lib, error) {
 	var (
 		lib Lib
 		err error
@@ -49,6 +49,7 @@ func macros(templates *template.Template) ([]macro.Macro, error) {
 			// TODO(seletskiy): more macros here
 		)),
 
+		nil,
 		templates,
 	)
 	if err != nil {
@@ -58,7 +59,7 @@ func macros(templates *template.Template) ([]macro.Macro, error) {
 	return macros, nil
 }
 
-func templates(api *confluence.API) (*template.Template, error) {
+func templates(api confluence.Client) (*template.Template, error) {
 	text := func(line ...string) string {
 		return strings.Join(line, ``)
 	}
@@ -66,9 +67,15 @@ func templates(api *confluence.API) (*template.Template, error) {
 	templates := template.New(`stdlib`).Funcs(
 		template.FuncMap{
 			"user": func(name string) *confluence.User {
+				// users can't be resolved without Confluence, e.g. in lint
+				// mode, so user name is rendered as is
+				if api == nil {
+					return nil
+				}
+
 				user, err := api.GetUserByName(name)
 				if err != nil {
-					log.Error(err)
+					log.Warningf(err, "unable to find user %q", name)
 				}
 
 				return user
@@ -149,6 +156,44 @@ func templates(api *confluence.API) (*template.Template, error) {
 			`</ac:structured-macro>`,
 		),
 
+		/* https://confluence.atlassian.com/doc/view-file-macro-151519248.html */
+
+		`ac:view-file`: text(
+			`<ac:structured-macro ac:name="view-file">`,
+			`<ac:parameter ac:name="name">`,
+			`<ri:attachment ri:filename="{{ .Name }}"/>`,
+			`</ac:parameter>`,
+			`<ac:parameter ac:name="height">{{ or .Height 250 }}</ac:parameter>`,
+			`</ac:structured-macro>`,
+		),
+
+		`ac:image`: text(
+			`<ac:image`,
+			`{{ if .Width }} ac:width="{{ .Width }}"{{ end }}`,
+			`{{ if .Height }} ac:height="{{ .Height }}"{{ end }}`,
+			`{{ if .Title }} ac:title="{{ .Title }}"{{ end }}`,
+			`{{ if .Alt }} ac:alt="{{ .Alt }}"{{ end }}>`,
+			`<ri:attachment ri:filename="{{ .Name }}"/>`,
+			`</ac:image>`,
+		),
+
+		/* https://confluence.atlassian.com/doc/multimedia-macro-163414066.html */
+
+		`ac:multimedia`: text(
+			`<ac:structured-macro ac:name="multimedia">`,
+			`<ac:parameter ac:name="name">`,
+			`<ri:attachment ri:filename="{{ .Name }}"/>`,
+			`</ac:parameter>`,
+			`{{ if .Width }}`,
+			/**/ `<ac:parameter ac:name="width">{{ .Width }}</ac:parameter>`,
+			`{{ end }}`,
+			`{{ if .Height }}`,
+			/**/ `<ac:parameter ac:name="height">{{ .Height }}</ac:parameter>`,
+			`{{ end }}`,
+			`<ac:parameter ac:name="autoplay">{{ or .AutoPlay false }}</ac:parameter>`,
+			`</ac:structured-macro>`,
+		),
+
 		// TODO(seletskiy): more templates here
 	} {
 		templates, err = templates.New(name).Parse(body)
@@ -0,0 +1,170 @@
+package mark
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/kovetskiy/mark/pkg/mark/position"
+	"github.com/kovetskiy/mark/pkg/mark/render"
+)
+
+var reTag = regexp.MustCompile(`<[^>]*>`)
+
+// StorageError describes position of the problem in the document in
+// Confluence storage format.
+type StorageError struct {
+	// Line is a line number in the document, starting from 1.
+	Line int
+
+	// Text is a content of the line.
+	Text string
+
+	// Offset is a byte offset of the element which caused the problem.
+	Offset int
+
+	Reason error
+}
+
+func (err StorageError) Error() string {
+	reason := err.Reason.Error()
+	if syntax, ok := err.Reason.(*xml.SyntaxError); ok {
+		reason = syntax.Msg
+	}
+
+	return fmt.Sprintf(
+		"invalid storage format: %s (compiled line %d: %q)",
+		reason,
+		err.Line,
+		strings.TrimSpace(err.Text),
+	)
+}
+
+// ValidateStorage checks that given document in Confluence storage format is
+// well-formed XML, so Confluence will accept it. Returned error points to the
+// element which caused the problem: unclosed element rather than closing tag
+// of its parent.
+func ValidateStorage(html string) error {
+	// document is parsed the same way as pages are rendered
+	decoder := render.NewStorageDecoder(html)
+
+	// offsets of start tags of currently open elements
+	open := []int{}
+
+	for {
+		offset := int(decoder.InputOffset()) - len(render.StorageHeader)
+
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err == nil {
+			switch token.(type) {
+			case xml.StartElement:
+				open = append(open, offset)
+
+			case xml.EndElement:
+				if len(open) > 0 {
+					open = open[:len(open)-1]
+				}
+			}
+
+			continue
+		}
+
+		// unclosed element is reported when closing tag of its parent or
+		// end of the document is reached
+		if len(open) > 1 && (offset >= len(html) ||
+			offset < 0 || strings.HasPrefix(html[offset:], "</")) {
+			offset = open[len(open)-1]
+		}
+
+		if offset < 0 {
+			offset = 0
+		}
+
+		if offset > len(html) {
+			offset = len(html)
+		}
+
+		line := strings.Count(html[:offset], "\n")
+
+		return StorageError{
+			Line:   line + 1,
+			Text:   strings.Split(html, "\n")[line],
+			Offset: offset,
+			Reason: err,
+		}
+	}
+}
+
+// FindSourcePosition returns position in the markdown file of the element
+// which starts at given offset in the compiled document, or zero position if
+// the element is not found. Markdown is processed contents of the file which
+// given map tracks lines of. Compiled document can't be mapped to markdown
+// directly, so the same occurrence of the start tag is looked up in
+// markdown, because raw HTML is passed through by markdown renderer. Text of
+// the line is looked up if there is no such tag in markdown.
+func FindSourcePosition(
+	markdown []byte,
+	lines *position.Map,
+	html string,
+	offset int,
+) position.Position {
+	index := -1
+
+	if tag := reTag.FindStringIndex(html[offset:]); tag != nil && tag[0] == 0 {
+		element := html[offset : offset+tag[1]]
+
+		index = findOccurrence(
+			markdown,
+			[]byte(element),
+			strings.Count(html[:offset], element),
+		)
+	}
+
+	if index < 0 {
+		start := strings.LastIndex(html[:offset], "\n") + 1
+
+		end := strings.Index(html[offset:], "\n")
+		if end < 0 {
+			end = len(html)
+		} else {
+			end += offset
+		}
+
+		text := strings.TrimSpace(reTag.ReplaceAllString(html[start:end], ""))
+		if text != "" {
+			index = bytes.Index(markdown, []byte(text))
+		}
+	}
+
+	if index < 0 {
+		return position.Position{}
+	}
+
+	return lines.Position(markdown, index)
+}
+
+// findOccurrence returns index of nth (starting from zero) occurrence of
+// given text in data, or index of the first one if there are fewer
+// occurrences, or -1 if there are none.
+func findOccurrence(data []byte, text []byte, nth int) int {
+	first := bytes.Index(data, text)
+
+	index := first
+	for i := 0; i < nth && index >= 0; i++ {
+		next := bytes.Index(data[index+len(text):], text)
+		if next < 0 {
+			return first
+		}
+
+		index += len(text) + next
+	}
+
+	return index
+}
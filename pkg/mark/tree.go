@@ -0,0 +1,187 @@
+package mark
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/kovetskiy/mark/pkg/confluence"
+	"github.com/reconquest/karma-go"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// TreeIndexFile is a markdown file of the page which corresponds to the
+	// directory it is placed in.
+	TreeIndexFile = `index.md`
+
+	// TreeMetaFile is a file which defines title of the page corresponding
+	// to the directory it is placed in, if there is no index file.
+	TreeMetaFile = `_meta.yml`
+)
+
+// SetTreeParents sets parents of the page from directories between root
+// directory and the file, so docs/ops/runbooks/db.md has parents "Ops" and
+// "Runbooks" if docs is the root. Parent headers in the file take
+// precedence over directories, as well as Parent headers in index files of
+// directories.
+//
+// Title of the directory is taken from Title header of index.md in the
+// directory, then from title field of _meta.yml, otherwise it's made of the
+// directory name. The file index.md itself is the page of its directory, so
+// the directory is not its parent.
+func SetTreeParents(root string, file string, meta *Meta) error {
+	if len(meta.Parents) > 0 || meta.Type == confluence.ContentTypeBlogPost {
+		return nil
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(file))
+	if err != nil {
+		return err
+	}
+
+	base, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+
+	if filepath.Base(file) == TreeIndexFile && dir != base {
+		dir = filepath.Dir(dir)
+	}
+
+	relative, err := filepath.Rel(base, dir)
+	if err != nil || relative == ".." ||
+		strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return fmt.Errorf(
+			"file %q is outside of tree root directory %q",
+			file,
+			root,
+		)
+	}
+
+	meta.Parents, err = getTreeAncestry(base, dir)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// getTreeAncestry returns titles of the page of given directory and its
+// parents, root directory is not a page itself.
+func getTreeAncestry(root string, dir string) ([]string, error) {
+	if dir == root {
+		return []string{}, nil
+	}
+
+	meta, err := readTreeIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var parents []string
+
+	if meta != nil && len(meta.Parents) > 0 {
+		parents = append([]string{}, meta.Parents...)
+	} else {
+		parents, err = getTreeAncestry(root, filepath.Dir(dir))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	title, err := getTreeTitle(dir, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(parents, title), nil
+}
+
+func getTreeTitle(dir string, index *Meta) (string, error) {
+	if index != nil && index.Title != "" {
+		return index.Title, nil
+	}
+
+	path := filepath.Join(dir, TreeMetaFile)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", karma.Format(err, "unable to read %q", path)
+	}
+
+	if err == nil {
+		var meta struct {
+			Title string `yaml:"title"`
+		}
+
+		err = yaml.Unmarshal(data, &meta)
+		if err != nil {
+			return "", karma.Format(err, "unable to parse %q", path)
+		}
+
+		if meta.Title != "" {
+			return meta.Title, nil
+		}
+	}
+
+	// directory name like db-backups becomes "Db backups"
+	title := []rune(strings.NewReplacer("-", " ", "_", " ").Replace(
+		filepath.Base(dir),
+	))
+
+	title[0] = unicode.ToUpper(title[0])
+
+	return string(title), nil
+}
+
+// readTreeIndex returns headers of index file of the directory or nil if
+// there is no index file or it has no headers.
+func readTreeIndex(dir string) (*Meta, error) {
+	path := filepath.Join(dir, TreeIndexFile)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, karma.Format(err, "unable to read %q", path)
+	}
+
+	// warnings of index file are reported when the file itself is
+	// processed, rather than for every file in its directory
+	meta, _, err := extractMeta(
+		path,
+		data,
+		func(error, string, ...interface{}) {},
+	)
+	if err != nil {
+		return nil, karma.Format(
+			err,
+			"unable to read index %q",
+			getWorkdirPath(path),
+		)
+	}
+
+	return meta, nil
+}
+
+// getWorkdirPath returns given absolute path relative to current directory
+// if it's inside of it, so it looks like paths of processed files.
+func getWorkdirPath(path string) string {
+	workdir, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+
+	relative, err := filepath.Rel(workdir, path)
+	if err != nil || strings.HasPrefix(relative, "..") {
+		return path
+	}
+
+	return relative
+}
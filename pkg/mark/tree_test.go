@@ -0,0 +1,67 @@
+package mark
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetTreeTitle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mark-tree-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	plain := filepath.Join(dir, "db-backups")
+	described := filepath.Join(dir, "ops")
+
+	for _, path := range []string{plain, described} {
+		err := os.MkdirAll(path, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = ioutil.WriteFile(
+		filepath.Join(described, TreeMetaFile),
+		[]byte("title: Operations\n"),
+		0644,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		dir      string
+		index    *Meta
+		expected string
+	}{
+		{"index title", described, &Meta{Title: "Runbooks"}, "Runbooks"},
+		{"meta file", described, nil, "Operations"},
+		{"empty index title", described, &Meta{}, "Operations"},
+		{"directory name", plain, nil, "Db backups"},
+		{"empty index title without meta file", plain, &Meta{}, "Db backups"},
+	}
+
+	for _, test := range tests {
+		title, err := getTreeTitle(test.dir, test.index)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		if title != test.expected {
+			t.Fatalf(
+				"%s: expected title %q, got %q",
+				test.name,
+				test.expected,
+				title,
+			)
+		}
+	}
+}
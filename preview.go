@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kovetskiy/mark/pkg/log"
+	"github.com/kovetskiy/mark/pkg/mark"
+	"github.com/kovetskiy/mark/pkg/mark/render"
+)
+
+// previewAttachments is URL path which attachments of previewed page are
+// served at.
+const previewAttachments = "/attachments/"
+
+// previewPage shows compiled markdown file and polls server to reload itself
+// when the file is changed.
+var previewPage = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .Title }}</title>
+<style>{{ .Stylesheet }}
+.preview-error { white-space: pre-wrap; background: #ffebe6; padding: 12px; }
+.preview-warning { background: #fffae6; padding: 4px 12px; margin: 4px 0; }
+</style>
+</head>
+<body>
+{{ if .Error }}<pre class="preview-error">{{ .Error }}</pre>{{ end }}
+{{ range .Warnings }}<div class="preview-warning">{{ . }}</div>{{ end }}
+<h1>{{ .Title }}</h1>
+{{ .Body }}
+<script>
+setInterval(function () {
+	fetch("/version").then(function (response) {
+		return response.text();
+	}).then(function (version) {
+		if (version !== "{{ .Version }}") {
+			location.reload();
+		}
+	}).catch(function () {});
+}, 1000);
+</script>
+</body>
+</html>
+`))
+
+// previewer compiles markdown file on every request and keeps track of files
+// it depends on to reload the page when any of them is changed.
+type previewer struct {
+	file string
+
+	mutex sync.Mutex
+
+	// version is incremented every time watched files are changed.
+	version int
+
+	// files are the markdown file, templates it includes and attachments.
+	files []string
+
+	// attachments maps names of attachments to local paths.
+	attachments map[string]string
+}
+
+// preview serves compiled markdown file at given address as HTML, which is an
+// approximation of the page in Confluence, until the program is interrupted.
+// It returns exit code of the program.
+func preview(file string, address string) int {
+	previewer := &previewer{
+		file:        file,
+		files:       []string{file},
+		attachments: map[string]string{},
+	}
+
+	// compile once to find files to watch and to report problems early
+	_, _, err := previewer.compile()
+	if err != nil {
+		log.Error(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", previewer.servePage)
+	mux.HandleFunc("/version", previewer.serveVersion)
+	mux.HandleFunc(previewAttachments, previewer.serveAttachment)
+
+	go previewer.watch()
+
+	log.Infof(nil, "serving preview of %q at http://%s/", file, address)
+
+	err = http.ListenAndServe(address, mux)
+	if err != nil {
+		log.Errorf(err, "unable to serve preview at %q", address)
+
+		return ExitConfig
+	}
+
+	return ExitSuccess
+}
+
+// compile compiles the file and returns its title and HTML. Files to watch
+// and attachments are updated even if compilation fails, so the page is
+// reloaded after the problem is fixed.
+func (previewer *previewer) compile() (string, string, error) {
+	rendered, err := renderFile(previewer.file, previewAttachments)
+
+	if rendered.files != nil {
+		previewer.mutex.Lock()
+		previewer.files = rendered.files
+		previewer.attachments = rendered.attachments
+		previewer.mutex.Unlock()
+	}
+
+	return rendered.title, rendered.html, err
+}
+
+// renderedFile is a markdown file compiled into HTML by renderFile.
+type renderedFile struct {
+	meta  *mark.Meta
+	title string
+	html  string
+
+	// files are the markdown file, templates it includes and attachments,
+	// they are known only if headers of the file are valid.
+	files []string
+
+	// attachments maps names of attachments to local paths.
+	attachments map[string]string
+}
+
+// renderFile compiles markdown file into HTML, which is an approximation of
+// the page in Confluence. Links to attachments are prefixed with given
+// attachments URL. Title, files and attachments of the result are filled as
+// soon as they are known, so they are available even if error is returned.
+func renderFile(file string, attachments string) (*renderedFile, error) {
+	rendered := &renderedFile{
+		meta:  &mark.Meta{},
+		title: filepath.Base(file),
+	}
+
+	source, err := readSource(nil, file)
+	if err != nil {
+		return rendered, err
+	}
+
+	if source.meta != nil {
+		rendered.meta = source.meta
+	}
+
+	meta := rendered.meta
+
+	if meta.Title != "" {
+		rendered.title = meta.Title
+	}
+
+	attaches, err := mark.ExpandAttachments(
+		filepath.Dir(file),
+		meta.Attachments,
+	)
+	if err != nil {
+		return rendered, err
+	}
+
+	rendered.files = source.files
+	rendered.attachments = map[string]string{}
+
+	for i, attach := range attaches {
+		attaches[i].Link = attachments + url.PathEscape(attach.Filename)
+
+		rendered.attachments[attach.Filename] = attach.Path
+
+		rendered.files = append(rendered.files, attach.Path)
+	}
+
+	markdown := mark.CompileAttachmentLinks(source.markdown, attaches)
+
+	html := mark.CompileMarkdown(markdown, source.stdlib)
+
+	err = source.validate(file, html)
+	if err != nil {
+		return rendered, err
+	}
+
+	var buffer bytes.Buffer
+
+	err = source.stdlib.Templates.ExecuteTemplate(
+		&buffer,
+		"ac:layout",
+		struct {
+			Layout string
+			Body   string
+		}{
+			Layout: meta.Layout,
+			Body:   html,
+		},
+	)
+	if err != nil {
+		return rendered, err
+	}
+
+	rendered.html, err = render.HTML(buffer.String(), attachments)
+	if err != nil {
+		return rendered, err
+	}
+
+	return rendered, nil
+}
+
+func (previewer *previewer) servePage(
+	writer http.ResponseWriter,
+	request *http.Request,
+) {
+	if request.URL.Path != "/" {
+		http.NotFound(writer, request)
+
+		return
+	}
+
+	previewer.mutex.Lock()
+	version := previewer.version
+	previewer.mutex.Unlock()
+
+	// warnings logged before belong to previous compilation
+	log.TakeWarnings()
+
+	title, html, err := previewer.compile()
+
+	data := struct {
+		Title      string
+		Body       template.HTML
+		Error      string
+		Warnings   []string
+		Version    int
+		Stylesheet template.CSS
+	}{
+		Title:      title,
+		Body:       template.HTML(html),
+		Warnings:   log.TakeWarnings(),
+		Version:    version,
+		Stylesheet: template.CSS(render.Stylesheet),
+	}
+
+	if err != nil {
+		log.Error(err)
+
+		data.Error = err.Error()
+	}
+
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	err = previewPage.Execute(writer, data)
+	if err != nil {
+		log.Errorf(err, "unable to write preview page")
+	}
+}
+
+func (previewer *previewer) serveVersion(
+	writer http.ResponseWriter,
+	request *http.Request,
+) {
+	previewer.mutex.Lock()
+	version := previewer.version
+	previewer.mutex.Unlock()
+
+	writer.Header().Set("Content-Type", "text/plain")
+	writer.Header().Set("Cache-Control", "no-store")
+
+	fmt.Fprint(writer, version)
+}
+
+func (previewer *previewer) serveAttachment(
+	writer http.ResponseWriter,
+	request *http.Request,
+) {
+	name := strings.TrimPrefix(request.URL.Path, previewAttachments)
+
+	previewer.mutex.Lock()
+	path, ok := previewer.attachments[name]
+	previewer.mutex.Unlock()
+
+	if !ok {
+		http.NotFound(writer, request)
+
+		return
+	}
+
+	http.ServeFile(writer, request, path)
+}
+
+// watch polls files the page depends on and increments version of the page
+// when any of them is changed, so browser reloads it.
+func (previewer *previewer) watch() {
+	var (
+		previous map[string]time.Time
+		watched  []string
+	)
+
+	for {
+		previewer.mutex.Lock()
+		files := previewer.files
+		previewer.mutex.Unlock()
+
+		current := getModTimes(files)
+
+		// list of files is changed after the page is compiled, so new
+		// files are not changes themselves
+		if strings.Join(files, "\n") != strings.Join(watched, "\n") {
+			previous = nil
+			watched = files
+		}
+
+		if previous != nil {
+			changed := getChangedFiles(previous, current)
+			if len(changed) > 0 {
+				log.Infof(
+					nil,
+					"%s changed, reloading preview",
+					strings.Join(changed, ", "),
+				)
+
+				previewer.mutex.Lock()
+				previewer.version++
+				previewer.mutex.Unlock()
+			}
+		}
+
+		previous = current
+
+		time.Sleep(pollInterval)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/kovetskiy/mark/pkg/confluence"
+	"github.com/kovetskiy/mark/pkg/log"
+	"github.com/kovetskiy/mark/pkg/mark"
+	"github.com/kovetskiy/mark/pkg/mark/merge"
+	"github.com/kovetskiy/mark/pkg/mark/render"
+	"github.com/reconquest/karma-go"
+)
+
+// pullFile converts current content of the page published from the file back
+// into markdown and replaces content of the file with it, keeping the header
+// block. If merge is set, changes made in Confluence since the last
+// publication are merged into the file instead, so includes, macros and
+// other local changes are preserved. If dryRun is set, resulting file is
+// printed instead of being written.
+func pullFile(
+	api confluence.Client,
+	creds *Credentials,
+	file string,
+	merge bool,
+	dryRun bool,
+	treeRoot string,
+) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return fail(ExitSource, err)
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fail(ExitSource, err)
+	}
+
+	meta, body, err := mark.ExtractMeta(file, data)
+	if err != nil {
+		return fail(ExitSource, err)
+	}
+
+	err = setTreeParents(treeRoot, file, meta)
+	if err != nil {
+		return err
+	}
+
+	page, err := getPulledPage(api, creds, meta)
+	if err != nil {
+		return err
+	}
+
+	if meta == nil {
+		meta = &mark.Meta{}
+	}
+
+	published, err := mark.GetPublishedVersion(api, page)
+	if err != nil {
+		return fail(ExitPublish, err)
+	}
+
+	if published == page.Version.Number {
+		log.Infof(
+			nil,
+			"page %q is not changed since the last publication",
+			page.Title,
+		)
+
+		return nil
+	}
+
+	attachments, err := getPulledAttachments(file, meta)
+	if err != nil {
+		return err
+	}
+
+	remote, err := getPageMarkdown(api, page, 0, attachments)
+	if err != nil {
+		return err
+	}
+
+	var (
+		content   = remote
+		conflicts = 0
+	)
+
+	if merge {
+		if published == 0 {
+			return fail(ExitPublish, fmt.Errorf(
+				"page %q was not published by mark, so changes "+
+					"can't be merged, pull without --merge to overwrite file",
+				page.Title,
+			))
+		}
+
+		base, err := getPageMarkdown(api, page, published, attachments)
+		if err != nil {
+			return err
+		}
+
+		content, conflicts = mergeMarkdown(file, base, string(body), remote)
+	}
+
+	header := data[:len(data)-len(body)]
+
+	result := string(header) + content
+
+	if dryRun {
+		fmt.Print(result)
+	} else {
+		err = ioutil.WriteFile(file, []byte(result), info.Mode())
+		if err != nil {
+			return karma.Format(err, "unable to write file %q", file)
+		}
+
+		log.Infof(nil, "page %q is pulled into %q", page.Title, file)
+	}
+
+	if conflicts > 0 {
+		return fail(ExitSource, fmt.Errorf(
+			"%s: %d conflicts between local and Confluence changes, "+
+				"resolve them before publishing the file",
+			file,
+			conflicts,
+		))
+	}
+
+	return nil
+}
+
+// getPulledPage returns page specified by URL in command line or page the
+// file is published to.
+func getPulledPage(
+	api confluence.Client,
+	creds *Credentials,
+	meta *mark.Meta,
+) (*confluence.PageInfo, error) {
+	if creds.PageID != "" {
+		page, err := api.GetPageByID(creds.PageID)
+		if err != nil {
+			return nil, fail(
+				ExitPublish,
+				karma.Format(err, "unable to retrieve page by id"),
+			)
+		}
+
+		return page, nil
+	}
+
+	if meta == nil {
+		return nil, fail(ExitSource, errors.New(
+			`specified file doesn't contain metadata `+
+				`and URL is not specified via command line `+
+				`or doesn't contain pageId GET-parameter`,
+		))
+	}
+
+	_, page, err := mark.ResolvePage(true, api, meta)
+	if err != nil {
+		return nil, fail(ExitPublish, karma.Describe("title", meta.Title).Format(
+			err,
+			"unable to resolve page",
+		))
+	}
+
+	if page == nil {
+		return nil, fail(ExitPublish, fmt.Errorf(
+			"page %q is not found in space %q",
+			meta.Title,
+			meta.Space,
+		))
+	}
+
+	return page, nil
+}
+
+// getPulledAttachments returns paths of attachments declared in the file by
+// their names in Confluence.
+func getPulledAttachments(
+	file string,
+	meta *mark.Meta,
+) (map[string]string, error) {
+	attaches, err := mark.ExpandAttachments(
+		filepath.Dir(file),
+		meta.Attachments,
+	)
+	if err != nil {
+		return nil, fail(ExitSource, err)
+	}
+
+	attachments := map[string]string{}
+	for _, attach := range attaches {
+		attachments[attach.Filename] = attach.Name
+	}
+
+	return attachments, nil
+}
+
+// getPageMarkdown converts given version of the page into markdown. Links to
+// attachments are replaced with paths of attachments declared in the file.
+func getPageMarkdown(
+	api confluence.Client,
+	page *confluence.PageInfo,
+	version int64,
+	attachments map[string]string,
+) (string, error) {
+	body, err := api.GetPageBody(page.ID, version)
+	if err != nil {
+		return "", fail(ExitPublish, karma.Format(
+			err,
+			"unable to get content of page %q",
+			page.Title,
+		))
+	}
+
+	document, err := render.Markdown(body, func(name string) string {
+		if path, ok := attachments[name]; ok {
+			return path
+		}
+
+		log.Warningf(
+			nil,
+			"attachment %q of page %q is not declared in the file",
+			name,
+			page.Title,
+		)
+
+		return name
+	})
+	if err != nil {
+		return "", fail(ExitPublish, karma.Format(
+			err,
+			"unable to convert page %q into markdown",
+			page.Title,
+		))
+	}
+
+	return document.Markdown, nil
+}
+
+// mergeMarkdown merges changes made in Confluence since the last publication
+// into local markdown. Base and remote are conversions of published and
+// current versions of the page, so parts of local markdown which were not
+// changed in Confluence are kept as is, even if they don't look like result
+// of conversion, e.g. includes and macros.
+func mergeMarkdown(file, base, local, remote string) (string, int) {
+	return merge.Merge(base, local, remote, merge.Labels{
+		Ours:   file,
+		Base:   "published",
+		Theirs: "confluence",
+	})
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// writeReport writes summary of processed files to given file. Report is
+// written in JUnit XML format if file has .xml extension and in Markdown
+// format otherwise.
+func writeReport(path string, results []*Result) error {
+	var (
+		report []byte
+		err    error
+	)
+
+	if strings.ToLower(filepath.Ext(path)) == ".xml" {
+		report, err = getJUnitReport(results)
+		if err != nil {
+			return err
+		}
+	} else {
+		report = getMarkdownReport(results)
+	}
+
+	return ioutil.WriteFile(path, report, 0644)
+}
+
+func getMarkdownReport(results []*Result) []byte {
+	var buffer bytes.Buffer
+
+	escape := strings.NewReplacer("|", `\|`, "\n", " ").Replace
+
+	fmt.Fprintln(&buffer, "# Confluence Publish Report")
+	fmt.Fprintln(&buffer)
+	fmt.Fprintln(&buffer, "| File | Page | Action | Version | Attachments |")
+	fmt.Fprintln(&buffer, "|------|------|--------|---------|-------------|")
+
+	for _, result := range results {
+		page := escape(result.Title)
+		if result.URL != "" {
+			page = fmt.Sprintf("[%s](%s)", page, result.URL)
+		}
+
+		action := result.Action
+		if result.Error != "" {
+			action = "failed"
+		}
+
+		version := ""
+		if result.Version > 0 {
+			version = fmt.Sprint(result.Version)
+		}
+
+		fmt.Fprintf(
+			&buffer,
+			"| %s | %s | %s | %s | %s |\n",
+			escape(result.File),
+			page,
+			action,
+			version,
+			escape(strings.Join(result.Attachments, ", ")),
+		)
+	}
+
+	for _, result := range results {
+		if len(result.Warnings) == 0 && result.Error == "" {
+			continue
+		}
+
+		fmt.Fprintln(&buffer)
+		fmt.Fprintf(&buffer, "## %s\n", result.File)
+		fmt.Fprintln(&buffer)
+
+		if result.Error != "" {
+			fmt.Fprintln(&buffer, "**Error**:")
+			fmt.Fprintln(&buffer)
+			fmt.Fprintln(&buffer, "```")
+			fmt.Fprintln(&buffer, result.Error)
+			fmt.Fprintln(&buffer, "```")
+			fmt.Fprintln(&buffer)
+		}
+
+		for _, warning := range result.Warnings {
+			fmt.Fprintf(&buffer, "- %s\n", escape(warning))
+		}
+	}
+
+	return buffer.Bytes()
+}
+
+type junitTestSuite struct {
+	XMLName  xml.Name        `xml:"testsuite"`
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+	SystemErr string        `xml:"system-err,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func getJUnitReport(results []*Result) ([]byte, error) {
+	suite := junitTestSuite{
+		Name:  "mark",
+		Tests: len(results),
+	}
+
+	var total int64
+
+	for _, result := range results {
+		total += result.Duration
+
+		testcase := junitTestCase{
+			Name:      result.File,
+			ClassName: "mark",
+			Time:      formatSeconds(result.Duration),
+			SystemErr: strings.Join(result.Warnings, "\n"),
+		}
+
+		if result.Error != "" {
+			suite.Failures++
+
+			testcase.Failure = &junitFailure{
+				Message: strings.SplitN(result.Error, "\n", 2)[0],
+				Text:    result.Error,
+			}
+		}
+
+		if result.Page != nil {
+			testcase.SystemOut = fmt.Sprintf(
+				"page: %s\nurl: %s\nversion: %d\n",
+				result.Title,
+				result.URL,
+				result.Version,
+			)
+
+			if result.Action != "" {
+				testcase.SystemOut += fmt.Sprintf(
+					"action: %s\n",
+					result.Action,
+				)
+			}
+
+			if len(result.Attachments) > 0 {
+				testcase.SystemOut += fmt.Sprintf(
+					"attachments: %s\n",
+					strings.Join(result.Attachments, ", "),
+				)
+			}
+		}
+
+		suite.Cases = append(suite.Cases, testcase)
+	}
+
+	suite.Time = formatSeconds(total)
+
+	report, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), append(report, '\n')...), nil
+}
+
+func formatSeconds(milliseconds int64) string {
+	return fmt.Sprintf("%.3f", float64(milliseconds)/1000)
+}
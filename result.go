@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kovetskiy/mark/pkg/confluence"
+	"github.com/kovetskiy/mark/pkg/log"
+)
+
+// Exit codes of the program, see usage.
+const (
+	ExitSuccess = 0
+	ExitFailure = 1
+	ExitConfig  = 2
+	ExitSource  = 3
+	ExitPublish = 4
+)
+
+const (
+	OutputText = `text`
+	OutputJSON = `json`
+)
+
+const (
+	ActionCreated   = `created`
+	ActionUpdated   = `updated`
+	ActionUnchanged = `unchanged`
+	ActionSkipped   = `skipped`
+)
+
+// Result describes what was done with the processed file.
+type Result struct {
+	File        string   `json:"file"`
+	PageID      string   `json:"page_id,omitempty"`
+	Title       string   `json:"title,omitempty"`
+	URL         string   `json:"url,omitempty"`
+	Action      string   `json:"action,omitempty"`
+	Version     int64    `json:"version,omitempty"`
+	Attachments []string `json:"attachments"`
+	Warnings    []string `json:"warnings"`
+	Duration    int64    `json:"duration_ms"`
+	Error       string   `json:"error,omitempty"`
+
+	// HTML is compiled page content in --compile-only and --dry-run modes.
+	HTML string `json:"html,omitempty"`
+
+	// Page is resolved page, which is nil if page was not resolved, e.g.
+	// in --compile-only mode.
+	Page *confluence.PageInfo `json:"-"`
+
+	// files are the file, templates it includes and its attachments, which
+	// are watched for changes in --watch mode.
+	files []string
+
+	// location identifies page by headers it was resolved by, see
+	// getPageLocation.
+	location string
+
+	started time.Time
+}
+
+// failure is an error which defines exit code of the program.
+type failure struct {
+	code   int
+	reason error
+}
+
+func (failure failure) Error() string {
+	return failure.reason.Error()
+}
+
+func fail(code int, reason error) error {
+	return failure{code: code, reason: reason}
+}
+
+// fatalf logs error and exits with given code.
+func fatalf(code int, reason error, message string, args ...interface{}) {
+	log.Errorf(reason, message, args...)
+
+	os.Exit(code)
+}
+
+func getExitCode(err error) int {
+	if failure, ok := err.(failure); ok {
+		return failure.code
+	}
+
+	return ExitFailure
+}
+
+func newResult(file string) *Result {
+	// warnings logged before belong to other files
+	log.TakeWarnings()
+
+	return &Result{
+		File:        file,
+		Attachments: []string{},
+		Warnings:    []string{},
+		started:     time.Now(),
+	}
+}
+
+// finish completes result and prints it in given output format.
+func (result *Result) finish(
+	output string,
+	baseURL string,
+	err error,
+) {
+	result.Duration = time.Since(result.started).Milliseconds()
+	result.Warnings = append(result.Warnings, log.TakeWarnings()...)
+
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	if result.Page != nil {
+		result.PageID = result.Page.ID
+		result.Title = result.Page.Title
+		result.Version = result.Page.Version.Number
+
+		if result.Page.Links.Full != "" {
+			result.URL = baseURL + result.Page.Links.Full
+		}
+	}
+
+	switch output {
+	case OutputJSON:
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+
+		err := encoder.Encode(result)
+		if err != nil {
+			log.Errorf(err, "unable to encode result")
+		}
+
+	default:
+		switch {
+		case err != nil:
+
+		case result.Action == ActionSkipped:
+			if result.HTML != "" {
+				fmt.Println(result.HTML)
+			}
+
+		default:
+			fmt.Println(result.URL)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/kovetskiy/mark/pkg/confluence"
+	"github.com/kovetskiy/mark/pkg/mark"
+	"github.com/kovetskiy/mark/pkg/mark/includes"
+	"github.com/kovetskiy/mark/pkg/mark/macro"
+	"github.com/kovetskiy/mark/pkg/mark/position"
+	"github.com/kovetskiy/mark/pkg/mark/stdlib"
+)
+
+// Source is a markdown file with extracted metadata and processed includes
+// and macros, which is ready to be compiled.
+type Source struct {
+	meta     *mark.Meta
+	markdown []byte
+	stdlib   *stdlib.Lib
+
+	// lines tracks lines of the file which lines of markdown come from.
+	lines *position.Map
+
+	// files are paths of the file and templates it uses, which are watched
+	// for changes. Built-in templates are listed as well, but they don't
+	// exist on disk.
+	files []string
+}
+
+// readSource reads markdown file and processes its headers, includes and
+// macros. If api is nil, users in templates are not resolved. Errors in
+// headers and directives are prefixed with file path and line number.
+func readSource(api confluence.Client, file string) (*Source, error) {
+	return parseSource(api, file, func(err error) error {
+		return fail(ExitSource, err)
+	})
+}
+
+// parseSource is readSource which passes problems of headers and directives
+// to given function and keeps processing the file if it returns nil, so all
+// problems of the file can be reported at once. Failed directives are
+// removed from the contents in that case.
+func parseSource(
+	api confluence.Client,
+	file string,
+	report func(error) error,
+) (*Source, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fail(ExitSource, err)
+	}
+
+	meta, markdown, err := mark.ExtractMeta(file, data)
+	if err != nil {
+		err = report(err)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// header block is stripped, so markdown starts at the line after it
+	lines := position.New(
+		file,
+		bytes.Count(data[:len(data)-len(markdown)], []byte("\n"))+1,
+		markdown,
+	)
+
+	if meta != nil {
+		meta.Source = mark.GetSourcePath(file)
+		meta.File = file
+	}
+
+	stdlib, err := stdlib.New(api)
+	if err != nil {
+		return nil, err
+	}
+
+	templates := stdlib.Templates
+
+	var (
+		recurse bool
+		files   = []string{file}
+	)
+
+	for {
+		files = append(files, includes.FindIncludes(markdown)...)
+
+		templates, markdown, recurse, err = includes.ProcessIncludes(
+			markdown,
+			lines,
+			templates,
+		)
+		if err != nil {
+			err = report(err)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if !recurse {
+			break
+		}
+	}
+
+	files = append(files, macro.FindTemplates(markdown)...)
+
+	macros, markdown, err := macro.ExtractMacros(markdown, lines, templates)
+	if err != nil {
+		err = report(err)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	macros = append(macros, stdlib.Macros...)
+
+	for _, macro := range macros {
+		markdown, err = macro.Apply(markdown, lines)
+		if err != nil {
+			err = report(err)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &Source{
+		meta:     meta,
+		markdown: markdown,
+		stdlib:   stdlib,
+		lines:    lines,
+		files:    files,
+	}, nil
+}
+
+// validate checks that compiled page is accepted by Confluence. Returned error
+// points to the line of the markdown file which the problem likely comes from.
+func (source *Source) validate(file string, html string) error {
+	err := mark.ValidateStorage(html)
+	if err == nil {
+		return nil
+	}
+
+	if storage, ok := err.(mark.StorageError); ok {
+		at := source.findPosition(html, storage)
+		if at.Line > 0 {
+			return fmt.Errorf("%s%s", at.Prefix(), err)
+		}
+	}
+
+	return fmt.Errorf("%s: %s", file, err)
+}
+
+// findPosition returns position in the file of the element which caused given
+// storage format error in compiled page.
+func (source *Source) findPosition(
+	html string,
+	storage mark.StorageError,
+) position.Position {
+	return mark.FindSourcePosition(
+		source.markdown,
+		source.lines,
+		html,
+		storage.Offset,
+	)
+}
+
+// setTreeParents derives parents of the page from directories between root
+// and the file, see mark.SetTreeParents. Nothing is changed if root is not
+// specified or the file has no headers.
+func setTreeParents(root string, file string, meta *mark.Meta) error {
+	if root == "" || meta == nil {
+		return nil
+	}
+
+	err := mark.SetTreeParents(root, file, meta)
+	if err != nil {
+		return fail(ExitSource, err)
+	}
+
+	return nil
+}
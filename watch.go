@@ -0,0 +1,177 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"time"
+
+	"github.com/kovetskiy/mark/pkg/log"
+)
+
+// pollInterval is how often watched files are checked for changes.
+const pollInterval = 500 * time.Millisecond
+
+// watchDebounce is how long files should stay unchanged after the last change
+// before they are republished, so editor saving several files or writing file
+// in several steps causes only one publication.
+const watchDebounce = time.Second
+
+// watchedFile is a markdown file which is republished in --watch mode.
+type watchedFile struct {
+	// result is a result of the last publication, it holds resolved page and
+	// files the markdown file depends on.
+	result *Result
+
+	// times are modification times of files after the last publication.
+	times map[string]time.Time
+}
+
+func (watched *watchedFile) getFiles() []string {
+	if len(watched.result.files) == 0 {
+		return []string{watched.result.File}
+	}
+
+	return watched.result.files
+}
+
+// getModTimes returns modification times of given files. Files which don't
+// exist are skipped, so both creation and removal of the file are detected as
+// a change.
+func getModTimes(files []string) map[string]time.Time {
+	times := map[string]time.Time{}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		times[file] = info.ModTime()
+	}
+
+	return times
+}
+
+// getChangedFiles returns files which were created, removed or modified
+// between two calls of getModTimes.
+func getChangedFiles(previous, current map[string]time.Time) []string {
+	changed := []string{}
+	for file, modified := range current {
+		if before, ok := previous[file]; !ok || !before.Equal(modified) {
+			changed = append(changed, file)
+		}
+	}
+
+	for file := range previous {
+		if _, ok := current[file]; !ok {
+			changed = append(changed, file)
+		}
+	}
+
+	return changed
+}
+
+// watchFiles polls files which were processed and republishes ones which
+// were changed, until the program is interrupted. Target is re-read on every
+// poll, so new markdown files in the directory are published as well.
+func watchFiles(
+	target string,
+	results []*Result,
+	publish func(file string, previous *Result) *Result,
+) {
+	watched := map[string]*watchedFile{}
+	for _, result := range results {
+		state := &watchedFile{result: result}
+		state.times = getModTimes(state.getFiles())
+
+		watched[result.File] = state
+	}
+
+	var (
+		pending   = map[string]bool{}
+		changedAt time.Time
+	)
+
+	log.Infof(nil, "watching %d files for changes", len(watched))
+
+	for {
+		time.Sleep(pollInterval)
+
+		files, err := resolveFiles(target)
+		if err == nil {
+			found := map[string]bool{}
+
+			for _, file := range files {
+				found[file] = true
+
+				if _, ok := watched[file]; !ok {
+					log.Infof(nil, "new file found: %q", file)
+
+					watched[file] = &watchedFile{result: &Result{File: file}}
+
+					pending[file] = true
+					changedAt = time.Now()
+				}
+			}
+
+			for file := range watched {
+				if !found[file] {
+					log.Infof(nil, "file is removed, stop watching: %q", file)
+
+					delete(watched, file)
+					delete(pending, file)
+				}
+			}
+		}
+
+		for file, state := range watched {
+			times := getModTimes(state.getFiles())
+
+			changed := getChangedFiles(state.times, times)
+			if len(changed) > 0 && state.times != nil {
+				log.Debugf(nil, "changed files of %q: %q", file, changed)
+
+				pending[file] = true
+				changedAt = time.Now()
+			}
+
+			state.times = times
+		}
+
+		if len(pending) == 0 || time.Since(changedAt) < watchDebounce {
+			continue
+		}
+
+		queue := []string{}
+		for file := range pending {
+			queue = append(queue, file)
+		}
+
+		sort.Strings(queue)
+
+		for _, file := range queue {
+			state := watched[file]
+
+			log.Infof(nil, "republishing changed file: %q", file)
+
+			result := publish(file, state.result)
+
+			// page is resolved again after failure, because it can be
+			// changed in Confluence meanwhile
+			if result.Error != "" {
+				result.Page = nil
+
+				if len(result.files) == 0 {
+					result.files = state.result.files
+				}
+			}
+
+			state.result = result
+
+			// file can be changed by publication itself, e.g. ID header is
+			// added with --write-back
+			state.times = getModTimes(state.getFiles())
+		}
+
+		pending = map[string]bool{}
+	}
+}